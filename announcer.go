@@ -0,0 +1,275 @@
+package torrentclient
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+const (
+	defaultAnnounceInterval = 30 * time.Minute
+	announceMinBackoff      = 15 * time.Second
+	announceMaxBackoff      = 30 * time.Minute
+	stoppedAnnounceTimeout  = 5 * time.Second
+)
+
+// Start starts the torrent, its trackers are announced to at the interval
+// they ask for, its peers are connected to and its web and HTTP seeds are
+// downloaded from in the background until Stop is called or the context is
+// done
+func (torrent *Torrent) Start(ctx context.Context) {
+	torrent.mu.Lock()
+	if torrent.stop != nil {
+		torrent.mu.Unlock()
+		return
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	torrent.stop = cancel
+	torrent.stopped = done
+	complete := torrent.hasMetadata() && torrent.isWantedComplete()
+	tasks := []func(context.Context){torrent.runAnnouncers, torrent.runConnections}
+	for _, ws := range torrent.WebSeeds {
+		tasks = append(tasks, func(ctx context.Context) {
+			ws.Run(ctx)
+		})
+	}
+	for _, hs := range torrent.HTTPSeeds {
+		tasks = append(tasks, func(ctx context.Context) {
+			hs.Run(ctx)
+		})
+	}
+	torrent.mu.Unlock()
+
+	if complete {
+		torrent.setState(StateSeeding)
+	} else {
+		torrent.setState(StateDownloading)
+	}
+	go func() {
+		defer close(done)
+		var wg sync.WaitGroup
+		for _, task := range tasks {
+			wg.Add(1)
+			go func(task func(context.Context)) {
+				defer wg.Done()
+				task(ctx)
+			}(task)
+		}
+		wg.Wait()
+	}()
+}
+
+// Stop stops the torrent, its peers are disconnected and the trackers are
+// sent the stopped event. Peers that connect to us are refused until it is
+// started again.
+func (torrent *Torrent) Stop() {
+	torrent.mu.Lock()
+	cancel, done := torrent.stop, torrent.stopped
+	torrent.stop, torrent.stopped = nil, nil
+	// the peers that connected to us do not run under the context of Start
+	disconnects := make([]context.CancelFunc, 0)
+	for _, p := range torrent.Peers {
+		if p.disconnect != nil {
+			disconnects = append(disconnects, p.disconnect)
+		}
+	}
+	torrent.mu.Unlock()
+	if cancel == nil {
+		return
+	}
+	cancel()
+	for _, disconnect := range disconnects {
+		disconnect()
+	}
+	<-done
+	torrent.setState(StateStopped)
+}
+
+// isStarted returns whether Start was called and Stop was not
+func (torrent *Torrent) isStarted() bool {
+	torrent.mu.Lock()
+	defer torrent.mu.Unlock()
+	return torrent.stop != nil
+}
+
+// runAnnouncers announces to the first tier that answers, or to every tier in
+// parallel with AnnounceToAllTiers
+func (torrent *Torrent) runAnnouncers(ctx context.Context) {
+	// the tiers grow when the metadata of a magnet link arrives
+	n := torrent.tierCount()
+	groups := make([][]int, 0)
+	if torrent.client.config.AnnounceToAllTiers {
+		for i := 0; i < n; i++ {
+			groups = append(groups, []int{i})
+		}
+	} else if n > 0 {
+		all := make([]int, n)
+		for i := range all {
+			all[i] = i
+		}
+		groups = append(groups, all)
+	}
+
+	var wg sync.WaitGroup
+	for _, tiers := range groups {
+		wg.Add(1)
+		go func(tiers []int) {
+			defer wg.Done()
+			torrent.runAnnouncer(ctx, tiers)
+		}(tiers)
+	}
+	wg.Wait()
+}
+
+// runAnnouncer announces to the tiers until the context is done, they are
+// tried in order and the first tracker that answers is used. Each tracker is
+// sent started first, then regular announces follow at the interval of the
+// tracker, completed once the download completes and stopped at the end.
+// Failures are retried with an exponential backoff.
+func (torrent *Torrent) runAnnouncer(ctx context.Context, tiers []int) {
+	seen, notify := torrent.completion()
+	started := make(map[*Tracker]bool)
+	completed := false
+	failures := 0
+	var last time.Time
+	var current *Tracker
+	event := func(t *Tracker) announceEvent {
+		switch {
+		case !started[t]:
+			return eventStarted
+		case completed:
+			return eventCompleted
+		default:
+			return eventNone
+		}
+	}
+	for {
+		var t *Tracker
+		var err error
+		for _, tier := range tiers {
+			t, err = torrent.announceTier(ctx, tier, event)
+			if err == nil || ctx.Err() != nil {
+				break
+			}
+		}
+		if ctx.Err() != nil {
+			// a started interrupted by Stop may have reached the tracker
+			if t != nil {
+				started[t] = true
+			}
+			break
+		}
+		var wait time.Duration
+		if err != nil {
+			failures++
+			wait = announceBackoff(failures)
+		} else {
+			if event(t) == eventCompleted {
+				completed = false
+			}
+			failures = 0
+			last = time.Now()
+			started[t] = true
+			current = t
+			wait = t.announceInterval()
+			if completed {
+				// the download completed before the tracker was started
+				wait = t.minInterval()
+			}
+		}
+
+		if !waitAnnounce(ctx, wait, notify) {
+			break
+		}
+		n, next := torrent.completion()
+		notify = next
+		if n == seen {
+			continue
+		}
+		seen = n
+		completed = true
+		if current != nil {
+			// the completed announce still honors the min interval
+			minWait := time.Until(last.Add(current.minInterval()))
+			if minWait > 0 && sleepContext(ctx, minWait) != nil {
+				break
+			}
+		}
+	}
+
+	for t := range started {
+		sctx, cancel := context.WithTimeout(context.Background(), stoppedAnnounceTimeout)
+		torrent.announce(sctx, t, eventStopped)
+		cancel()
+	}
+}
+
+// waitAnnounce waits for the next announce or for the download to complete,
+// false is returned if the context is done
+func waitAnnounce(ctx context.Context, wait time.Duration, completed <-chan struct{}) bool {
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	case <-completed:
+		return true
+	}
+}
+
+// completion returns how many times the download of the torrent completed
+// and a channel closed on the next completion
+func (torrent *Torrent) completion() (int, <-chan struct{}) {
+	torrent.mu.Lock()
+	defer torrent.mu.Unlock()
+	if torrent.completeNotify == nil {
+		torrent.completeNotify = make(chan struct{})
+	}
+	return torrent.completions, torrent.completeNotify
+}
+
+// markComplete records that the download completed and wakes the announcers.
+// The torrent must be locked.
+func (torrent *Torrent) markComplete() {
+	torrent.completions++
+	if torrent.completeNotify != nil {
+		close(torrent.completeNotify)
+		torrent.completeNotify = nil
+	}
+}
+
+// announceInterval returns the time to wait before the next regular announce
+func (tracker *Tracker) announceInterval() time.Duration {
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+	interval := time.Duration(tracker.Interval) * time.Second
+	if interval <= 0 {
+		interval = defaultAnnounceInterval
+	}
+	if min := time.Duration(tracker.MinInterval) * time.Second; interval < min {
+		interval = min
+	}
+	return interval
+}
+
+// minInterval returns the minimum time between two announces the tracker
+// asked for
+func (tracker *Tracker) minInterval() time.Duration {
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+	return time.Duration(tracker.MinInterval) * time.Second
+}
+
+func announceBackoff(failures int) time.Duration {
+	d := announceMinBackoff
+	for i := 1; i < failures && d < announceMaxBackoff; i++ {
+		d *= 2
+	}
+	if d > announceMaxBackoff {
+		d = announceMaxBackoff
+	}
+	return d
+}
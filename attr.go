@@ -0,0 +1,80 @@
+package torrentclient
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// IsPad returns whether the file is a pad file, zeros aligning the next file
+// to a piece boundary
+func (f *File) IsPad() bool {
+	return strings.Contains(f.Attr, "p")
+}
+
+// IsExecutable returns whether the file is executable
+func (f *File) IsExecutable() bool {
+	return strings.Contains(f.Attr, "x")
+}
+
+// IsHidden returns whether the file is hidden
+func (f *File) IsHidden() bool {
+	return strings.Contains(f.Attr, "h")
+}
+
+// IsSymlink returns whether the file is a symlink to SymlinkPath
+func (f *File) IsSymlink() bool {
+	return strings.Contains(f.Attr, "l") && f.SymlinkPath != ""
+}
+
+// fileMode returns the mode a file is created with
+func (f *File) fileMode() os.FileMode {
+	if f.IsExecutable() {
+		return 0755
+	}
+	return 0644
+}
+
+// createLinks creates the symlinks and the empty files of the torrent, which
+// are never written by pieces
+func (s *storage) createLinks() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, f := range s.torrent.Files {
+		switch {
+		case f.IsPad():
+		case f.IsSymlink():
+			err := s.createSymlink(i)
+			if err != nil {
+				return err
+			}
+		case f.Length == 0:
+			_, err := s.openFile(i, true)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (s *storage) createSymlink(index int) error {
+	p := s.filePath(index)
+	root := s.dir
+	if s.torrent.multiFile {
+		root = filepath.Join(s.dir, s.torrent.Name)
+	}
+	target, err := filepath.Rel(filepath.Dir(p), filepath.Join(root, filepath.FromSlash(s.torrent.Files[index].SymlinkPath)))
+	if err != nil {
+		return err
+	}
+	if current, err := os.Readlink(p); err == nil && current == target {
+		return nil
+	}
+	err = os.MkdirAll(filepath.Dir(p), 0755)
+	if err != nil {
+		return err
+	}
+	os.Remove(p)
+	return os.Symlink(target, p)
+}
@@ -0,0 +1,90 @@
+package torrentclient
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	bencode "github.com/tharindu96/bencode-go"
+)
+
+func Test_FileAttributes(t *testing.T) {
+	torrent, data := newTestTorrent(t, 16, 10, 6, 16, 0)
+	// the second file pads the first one to the piece boundary
+	copy(data[10:16], make([]byte, 6))
+	sum := sha1.Sum(data[:16])
+	torrent.Pieces[0].Hash = string(sum[:])
+	torrent.Files[1].Attr = "p"
+	torrent.Files[1].priority = PrioritySkip
+	torrent.Files[2].Attr = "x"
+	torrent.Files[3].Attr = "l"
+	torrent.Files[3].SymlinkPath = "dir/a"
+	torrent.mu.Lock()
+	torrent.updatePiecePriorities()
+	torrent.mu.Unlock()
+
+	for i := range torrent.Pieces {
+		ok, err := torrent.pieceDownloaded(i, data[i*16:(i+1)*16])
+		if err != nil || !ok {
+			t.Fatalf("piece %d: %v %v", i, ok, err)
+		}
+	}
+
+	root := filepath.Join(torrent.client.config.DataDir, "test", "dir")
+	if _, err := os.Stat(filepath.Join(root, "b")); !os.IsNotExist(err) {
+		t.Errorf("pad file created: %v", err)
+	}
+	info, err := os.Stat(filepath.Join(root, "c"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm()&0100 == 0 {
+		t.Errorf("executable bit not set: %s", info.Mode())
+	}
+	target, err := os.Readlink(filepath.Join(root, "d"))
+	if err != nil || target != "a" {
+		t.Errorf("unexpected symlink %q %v", target, err)
+	}
+
+	buff := make([]byte, 32)
+	_, err = torrent.storage.ReadAt(buff, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buff, data[:32]) {
+		t.Error("pad file not read as zeros")
+	}
+}
+
+func Test_ParseFileAttributes(t *testing.T) {
+	info := "d5:filesld4:attr1:p6:lengthi6e4:pathl1:beed4:attr1:x6:lengthi10e4:pathl1:ceed4:attr1:l6:lengthi0e4:pathl1:de12:symlink pathl3:dir1:aeee" +
+		"4:name3:dir12:piece lengthi16e6:pieces20:" + strings.Repeat("x", 20) + "e"
+	node, err := bencode.BRead(bufio.NewReader(strings.NewReader(info)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dict, err := node.GetDict()
+	if err != nil {
+		t.Fatal(err)
+	}
+	files, err := getFiles(&dict)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 3 {
+		t.Fatalf("expected 3 files, got %d", len(files))
+	}
+	if !files[0].IsPad() || files[0].priority != PrioritySkip {
+		t.Errorf("pad file not parsed: %+v", files[0])
+	}
+	if !files[1].IsExecutable() || files[1].IsSymlink() {
+		t.Errorf("executable file not parsed: %+v", files[1])
+	}
+	if !files[2].IsSymlink() || files[2].SymlinkPath != "dir/a" {
+		t.Errorf("symlink not parsed: %+v", files[2])
+	}
+}
@@ -0,0 +1,260 @@
+package torrentclient
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Config holds the options of a TorrentClient
+type Config struct {
+	// PeerID is sent to trackers and peers to identify the client
+	PeerID string `json:"peer_id"`
+	// ListenAddr is the address the client accepts peer connections on
+	ListenAddr string `json:"listen_addr"`
+	// DataDir is the directory torrents are downloaded into
+	DataDir string `json:"data_dir"`
+	// UserAgent is sent to HTTP trackers
+	UserAgent string `json:"user_agent"`
+	// AnnounceIP is sent to trackers as our address when it is set
+	AnnounceIP string `json:"announce_ip"`
+	// NumWant is the number of peers asked from trackers
+	NumWant int `json:"numwant"`
+
+	MaxConnections           int `json:"max_connections"`
+	MaxConnectionsPerTorrent int `json:"max_connections_per_torrent"`
+	MaxDialing               int `json:"max_dialing"`
+	UploadSlots              int `json:"upload_slots"`
+	DownloadSlots            int `json:"download_slots"`
+	RequestQueueDepth        int `json:"request_queue_depth"`
+	BlockSize                int `json:"block_size"`
+
+	// Transfer limits in bytes per second, 0 means unlimited. Peers on the
+	// local network use the local limits when SeparateLocalLimits is set.
+	DownloadLimit       int  `json:"download_limit"`
+	UploadLimit         int  `json:"upload_limit"`
+	LocalDownloadLimit  int  `json:"local_download_limit"`
+	LocalUploadLimit    int  `json:"local_upload_limit"`
+	SeparateLocalLimits bool `json:"separate_local_limits"`
+
+	DialTimeout      time.Duration `json:"-"`
+	HandshakeTimeout time.Duration `json:"-"`
+	TrackerTimeout   time.Duration `json:"-"`
+	// SeedTimeout is how long a web or HTTP seed may take to answer or to
+	// send more data, time spent waiting for the rate limits is not counted
+	SeedTimeout time.Duration `json:"-"`
+
+	// KeepAliveInterval is how long a connection may stay idle before a
+	// keep-alive is sent, peers sending nothing for PeerTimeout are
+	// disconnected and peers not sending a requested block for SnubTimeout
+	// are snubbed
+	KeepAliveInterval time.Duration `json:"-"`
+	PeerTimeout       time.Duration `json:"-"`
+	SnubTimeout       time.Duration `json:"-"`
+
+	Encryption EncryptionPolicy `json:"encryption"`
+
+	// AnnounceToAllTiers announces to one tracker of every tier in parallel
+	// instead of only to the first tier that answers
+	AnnounceToAllTiers bool `json:"announce_to_all_tiers"`
+
+	DHT bool `json:"dht"`
+	PEX bool `json:"pex"`
+	LSD bool `json:"lsd"`
+}
+
+// EncryptionPolicy decides how peer connections are encrypted
+type EncryptionPolicy string
+
+// EncryptionPolicy Constants
+const (
+	EncryptionDisabled  EncryptionPolicy = "disabled"
+	EncryptionPreferred EncryptionPolicy = "preferred"
+	EncryptionRequired  EncryptionPolicy = "required"
+)
+
+// DefaultConfig returns the default options
+func DefaultConfig() *Config {
+	return &Config{
+		PeerID:                   GeneratePeerID(),
+		ListenAddr:               ":6881",
+		DataDir:                  ".",
+		UserAgent:                "torrentclient-go",
+		NumWant:                  50,
+		MaxConnections:           200,
+		MaxConnectionsPerTorrent: 50,
+		MaxDialing:               10,
+		UploadSlots:              4,
+		DownloadSlots:            20,
+		RequestQueueDepth:        5,
+		BlockSize:                16384,
+		DialTimeout:              10 * time.Second,
+		HandshakeTimeout:         10 * time.Second,
+		TrackerTimeout:           30 * time.Second,
+		SeedTimeout:              30 * time.Second,
+		KeepAliveInterval:        2 * time.Minute,
+		PeerTimeout:              3 * time.Minute,
+		SnubTimeout:              time.Minute,
+		Encryption:               EncryptionDisabled,
+		DHT:                      true,
+		PEX:                      true,
+		LSD:                      true,
+	}
+}
+
+// LoadConfig reads a JSON config file, options missing from the file keep
+// their default values
+func LoadConfig(filepath string) (*Config, error) {
+	b, err := os.ReadFile(filepath)
+	if err != nil {
+		return nil, err
+	}
+	config := DefaultConfig()
+	err = json.Unmarshal(b, config)
+	if err != nil {
+		return nil, err
+	}
+	err = config.Validate()
+	if err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// UnmarshalJSON reads the timeouts as duration strings such as "10s"
+func (config *Config) UnmarshalJSON(b []byte) error {
+	type plain Config
+	aux := struct {
+		*plain
+		DialTimeout       string `json:"dial_timeout"`
+		HandshakeTimeout  string `json:"handshake_timeout"`
+		TrackerTimeout    string `json:"tracker_timeout"`
+		SeedTimeout       string `json:"seed_timeout"`
+		KeepAliveInterval string `json:"keepalive_interval"`
+		PeerTimeout       string `json:"peer_timeout"`
+		SnubTimeout       string `json:"snub_timeout"`
+	}{plain: (*plain)(config)}
+	err := json.Unmarshal(b, &aux)
+	if err != nil {
+		return err
+	}
+	durations := []struct {
+		s string
+		d *time.Duration
+	}{
+		{aux.DialTimeout, &config.DialTimeout},
+		{aux.HandshakeTimeout, &config.HandshakeTimeout},
+		{aux.TrackerTimeout, &config.TrackerTimeout},
+		{aux.SeedTimeout, &config.SeedTimeout},
+		{aux.KeepAliveInterval, &config.KeepAliveInterval},
+		{aux.PeerTimeout, &config.PeerTimeout},
+		{aux.SnubTimeout, &config.SnubTimeout},
+	}
+	for _, v := range durations {
+		if v.s == "" {
+			continue
+		}
+		d, err := time.ParseDuration(v.s)
+		if err != nil {
+			return err
+		}
+		*v.d = d
+	}
+	return nil
+}
+
+// MarshalJSON writes the timeouts as duration strings, the way UnmarshalJSON
+// reads them
+func (config Config) MarshalJSON() ([]byte, error) {
+	type plain Config
+	return json.Marshal(struct {
+		*plain
+		DialTimeout       string `json:"dial_timeout"`
+		HandshakeTimeout  string `json:"handshake_timeout"`
+		TrackerTimeout    string `json:"tracker_timeout"`
+		SeedTimeout       string `json:"seed_timeout"`
+		KeepAliveInterval string `json:"keepalive_interval"`
+		PeerTimeout       string `json:"peer_timeout"`
+		SnubTimeout       string `json:"snub_timeout"`
+	}{
+		plain:             (*plain)(&config),
+		DialTimeout:       config.DialTimeout.String(),
+		HandshakeTimeout:  config.HandshakeTimeout.String(),
+		TrackerTimeout:    config.TrackerTimeout.String(),
+		SeedTimeout:       config.SeedTimeout.String(),
+		KeepAliveInterval: config.KeepAliveInterval.String(),
+		PeerTimeout:       config.PeerTimeout.String(),
+		SnubTimeout:       config.SnubTimeout.String(),
+	})
+}
+
+// Validate checks the options and returns all the problems found
+func (config *Config) Validate() error {
+	errs := make([]error, 0)
+	if config.PeerID == "" || len(config.PeerID) > 20 {
+		errs = append(errs, errors.New("peer id must be between 1 and 20 bytes"))
+	}
+	if _, err := parsePort(config.ListenAddr); err != nil {
+		errs = append(errs, fmt.Errorf("listen address: %w", err))
+	}
+	if config.DataDir == "" {
+		errs = append(errs, errors.New("data directory is empty"))
+	}
+	if config.NumWant < 0 {
+		errs = append(errs, errors.New("numwant must not be negative"))
+	}
+	if config.MaxConnections <= 0 {
+		errs = append(errs, errors.New("max connections must be positive"))
+	}
+	if config.MaxConnectionsPerTorrent <= 0 || config.MaxConnectionsPerTorrent > config.MaxConnections {
+		errs = append(errs, errors.New("max connections per torrent must be positive and at most max connections"))
+	}
+	if config.MaxDialing <= 0 {
+		errs = append(errs, errors.New("max dialing must be positive"))
+	}
+	if config.UploadSlots < 0 {
+		errs = append(errs, errors.New("upload slots must not be negative"))
+	}
+	if config.DownloadSlots <= 0 {
+		errs = append(errs, errors.New("download slots must be positive"))
+	}
+	if config.RequestQueueDepth <= 0 {
+		errs = append(errs, errors.New("request queue depth must be positive"))
+	}
+	if config.BlockSize <= 0 || config.BlockSize > maxBlockSize {
+		errs = append(errs, fmt.Errorf("block size must be between 1 and %d", maxBlockSize))
+	}
+	if config.DownloadLimit < 0 || config.UploadLimit < 0 || config.LocalDownloadLimit < 0 || config.LocalUploadLimit < 0 {
+		errs = append(errs, errors.New("transfer limits must not be negative"))
+	}
+	if config.DialTimeout <= 0 || config.HandshakeTimeout <= 0 || config.TrackerTimeout <= 0 || config.SeedTimeout <= 0 {
+		errs = append(errs, errors.New("timeouts must be positive"))
+	}
+	if config.KeepAliveInterval <= 0 || config.SnubTimeout <= 0 || config.PeerTimeout <= config.KeepAliveInterval {
+		errs = append(errs, errors.New("keep-alive interval and snub timeout must be positive, peer timeout longer than the keep-alive interval"))
+	}
+	switch config.Encryption {
+	case EncryptionDisabled:
+	case EncryptionPreferred, EncryptionRequired:
+		errs = append(errs, errors.New("encryption is not supported, it must be disabled"))
+	default:
+		errs = append(errs, fmt.Errorf("unknown encryption policy %q", config.Encryption))
+	}
+	return errors.Join(errs...)
+}
+
+func parsePort(addr string) (uint16, error) {
+	_, sport, err := net.SplitHostPort(addr)
+	if err != nil {
+		return 0, err
+	}
+	port, err := strconv.ParseUint(sport, 10, 16)
+	if err != nil {
+		return 0, err
+	}
+	return uint16(port), nil
+}
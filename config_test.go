@@ -0,0 +1,56 @@
+package torrentclient
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func Test_LoadConfig(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "config.json")
+	err := os.WriteFile(p, []byte(`{"listen_addr": ":7000", "dial_timeout": "3s", "dht": false}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config, err := LoadConfig(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.ListenAddr != ":7000" || config.DialTimeout != 3*time.Second || config.DHT {
+		t.Errorf("options not read from the file: %+v", config)
+	}
+	if config.BlockSize != DefaultConfig().BlockSize {
+		t.Errorf("default block size not kept: %d", config.BlockSize)
+	}
+
+	config.RequestQueueDepth = 0
+	if config.Validate() == nil {
+		t.Error("invalid config accepted")
+	}
+}
+
+func Test_ConfigJSON(t *testing.T) {
+	config := DefaultConfig()
+	config.DialTimeout = 3 * time.Second
+	config.SnubTimeout = 90 * time.Second
+	b, err := json.Marshal(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded := &Config{}
+	err = json.Unmarshal(b, loaded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *loaded != *config {
+		t.Errorf("config changed by a round trip:\n%+v\n%+v", config, loaded)
+	}
+
+	config.Encryption = EncryptionPreferred
+	if config.Validate() == nil {
+		t.Error("encryption accepted while it is not supported")
+	}
+}
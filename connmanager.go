@@ -0,0 +1,234 @@
+package torrentclient
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	connectInterval    = time.Second
+	peerRetryMin       = 30 * time.Second
+	peerRetryMax       = 30 * time.Minute
+	peerMaxFailures    = 5
+	peerReconnectDelay = time.Minute
+	peerLimitDelay     = 5 * time.Second
+	peerIdleTimeout    = 5 * time.Minute
+)
+
+// sourceRank orders the sources peers are tried from, local peers first
+var sourceRank = map[peerSource]int{
+	sourceLSD:     0,
+	sourceTracker: 1,
+	sourcePEX:     2,
+	sourceDHT:     3,
+}
+
+// runConnections dials the known peers of the torrent until the context is
+// done, keeping the connections within the limits of the config
+func (torrent *Torrent) runConnections(ctx context.Context) {
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	ticker := time.NewTicker(connectInterval)
+	defer ticker.Stop()
+	for {
+		for _, peer := range torrent.connectCandidates() {
+			wg.Add(1)
+			go func(peer *Peer) {
+				defer wg.Done()
+				torrent.connectPeer(ctx, peer)
+			}(peer)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// connectCandidates returns the peers to dial now, best first, and marks them
+// as dialing. When the torrent is at its connection limit an idle peer is
+// disconnected to make room.
+func (torrent *Torrent) connectCandidates() []*Peer {
+	config := torrent.client.config
+	torrent.mu.Lock()
+	defer torrent.mu.Unlock()
+
+	now := time.Now()
+	dialing := 0
+	candidates := make([]*Peer, 0)
+	for _, p := range torrent.Peers {
+		switch {
+		case p.dialing:
+			dialing++
+		case p.connected || p.source == sourceIncoming || now.Before(p.retryAt):
+		default:
+			candidates = append(candidates, p)
+		}
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	n := min(config.MaxDialing-dialing, config.MaxConnectionsPerTorrent-torrent.connections)
+	if n <= 0 {
+		if dialing == 0 {
+			torrent.dropIdlePeer(now)
+		}
+		return nil
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		return peerBefore(candidates[i], candidates[j])
+	})
+	if len(candidates) > n {
+		candidates = candidates[:n]
+	}
+	for _, p := range candidates {
+		p.dialing = true
+	}
+	return candidates
+}
+
+// peerBefore orders peers by what they gave before, their failures and their
+// source
+func peerBefore(a *Peer, b *Peer) bool {
+	da, db := a.stats.downloadedPayload.Load(), b.stats.downloadedPayload.Load()
+	if da != db {
+		return da > db
+	}
+	if a.failures != b.failures {
+		return a.failures < b.failures
+	}
+	return sourceRank[a.source] < sourceRank[b.source]
+}
+
+// dropIdlePeer disconnects the connected peer that exchanged no data for the
+// longest time, if it is idle for more than peerIdleTimeout. The torrent must
+// be locked.
+func (torrent *Torrent) dropIdlePeer(now time.Time) {
+	var idle *Peer
+	for _, p := range torrent.Peers {
+		if !p.connected || p.disconnect == nil || now.Sub(p.lastActive) < peerIdleTimeout {
+			continue
+		}
+		if idle == nil || p.lastActive.Before(idle.lastActive) {
+			idle = p
+		}
+	}
+	if idle != nil {
+		idle.disconnect()
+	}
+}
+
+// connectPeer connects to the peer and schedules the next attempt once the
+// connection ends, peers failing too often are forgotten
+func (torrent *Torrent) connectPeer(ctx context.Context, peer *Peer) {
+	start := time.Now()
+	err := peer.Connect(ctx)
+
+	torrent.mu.Lock()
+	defer torrent.mu.Unlock()
+	peer.dialing = false
+	now := time.Now()
+	switch {
+	case ctx.Err() != nil:
+	case errors.Is(err, ErrDuplicatePeer) || torrent.isDuplicate(peer):
+		torrent.removePeer(peer)
+	case errors.Is(err, ErrTooManyConnections):
+		peer.retryAt = now.Add(peerLimitDelay)
+	case peer.connectedAt.After(start):
+		peer.failures = 0
+		peer.retryAt = now.Add(peerReconnectDelay)
+	default:
+		peer.failures++
+		if peer.failures >= peerMaxFailures {
+			torrent.removePeer(peer)
+			return
+		}
+		peer.retryAt = now.Add(peerRetryDelay(peer.failures))
+	}
+}
+
+// isDuplicate returns true if another known peer has the id of the peer, the
+// remote side may refuse the second connection without telling us why. The
+// torrent must be locked.
+func (torrent *Torrent) isDuplicate(peer *Peer) bool {
+	if peer.ID == "" {
+		return false
+	}
+	for _, p := range torrent.Peers {
+		if p != peer && p.ID == peer.ID {
+			return true
+		}
+	}
+	return false
+}
+
+func peerRetryDelay(failures int) time.Duration {
+	d := peerRetryMin
+	for i := 1; i < failures && d < peerRetryMax; i++ {
+		d *= 2
+	}
+	return min(d, peerRetryMax)
+}
+
+// registerPeer marks the peer as connected once its handshake is done, a
+// second connection to the same peer id or one to ourselves is refused and
+// other addresses known for the peer id are forgotten. Peers connecting to a
+// stopped torrent are refused.
+func (torrent *Torrent) registerPeer(peer *Peer, disconnect context.CancelFunc) error {
+	torrent.mu.Lock()
+	defer torrent.mu.Unlock()
+	if peer.source == sourceIncoming && torrent.stop == nil {
+		return ErrTorrentStopped
+	}
+	if peer.ID == padPeerID(torrent.client.GetID()) {
+		return ErrDuplicatePeer
+	}
+	for _, p := range torrent.Peers {
+		if p != peer && p.connected && p.ID == peer.ID {
+			return ErrDuplicatePeer
+		}
+	}
+	for _, p := range torrent.Peers {
+		if p != peer && p.ID == peer.ID {
+			torrent.removePeer(p)
+		}
+	}
+	now := time.Now()
+	peer.connected = true
+	peer.connectedAt = now
+	peer.lastActive = now
+	peer.disconnect = disconnect
+	return nil
+}
+
+// unregisterPeer marks the peer as disconnected, peers that connected to us
+// are forgotten as their address can not be dialed
+func (torrent *Torrent) unregisterPeer(peer *Peer) {
+	torrent.mu.Lock()
+	defer torrent.mu.Unlock()
+	peer.connected = false
+	peer.disconnect = nil
+	if peer.source == sourceIncoming {
+		torrent.removePeer(peer)
+	}
+}
+
+// removePeer removes the peer from the peer list. The torrent must be locked.
+func (torrent *Torrent) removePeer(peer *Peer) {
+	key := peer.Addr.String()
+	if torrent.Peers[key] == peer {
+		delete(torrent.Peers, key)
+	}
+}
+
+// touch records that data was exchanged with the peer
+func (peer *Peer) touch() {
+	peer.torrent.mu.Lock()
+	peer.lastActive = time.Now()
+	peer.torrent.mu.Unlock()
+}
@@ -0,0 +1,181 @@
+package torrentclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+func Test_ConnectionManager(t *testing.T) {
+	seeder, data := newTestTorrent(t, 16, 20, 30)
+	leecher, _ := newTestTorrent(t, 16, 20, 30)
+	for i := range seeder.Pieces {
+		_, err := seeder.pieceDownloaded(i, data[i*16:min((i+1)*16, len(data))])
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	seeder.client.addTorrentIfAbsent(seeder)
+	seeder.client.id = GeneratePeerID()
+
+	listener, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	port := listener.Addr().(*net.TCPAddr).Port
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	seeder.Start(ctx)
+	defer seeder.Stop()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go seeder.client.handleIncoming(ctx, conn)
+		}
+	}()
+
+	// a second address of the seeder, forwarding to the first one
+	proxy, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer proxy.Close()
+	go func() {
+		for {
+			conn, err := proxy.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				upstream, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+				if err != nil {
+					return
+				}
+				defer upstream.Close()
+				go io.Copy(upstream, conn)
+				io.Copy(conn, upstream)
+			}(conn)
+		}
+	}()
+
+	// the seeder twice and a peer that can not be reached
+	addrs := []string{fmt.Sprintf("127.0.0.1:%d", port), proxy.Addr().String(), "127.0.0.1:1"}
+	peers := make([]*Peer, 0)
+	for _, a := range addrs {
+		peers = append(peers, &Peer{torrent: leecher, Addr: netip.MustParseAddrPort(a)})
+	}
+	leecher.addPeers(peers, sourceTracker)
+
+	sub := leecher.client.Subscribe(leecher, 64)
+	defer sub.Unsubscribe()
+	leecher.Start(ctx)
+	defer leecher.Stop()
+
+	for done := false; !done; {
+		select {
+		case e := <-sub.C:
+			_, done = e.(DownloadCompleteEvent)
+		case <-ctx.Done():
+			t.Fatal("download did not complete")
+		}
+	}
+
+	// the duplicate connection ends on its own
+	for ctx.Err() == nil {
+		leecher.mu.Lock()
+		n := len(leecher.Peers)
+		leecher.mu.Unlock()
+		if n == len(addrs)-1 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	leecher.mu.Lock()
+	defer leecher.mu.Unlock()
+	connected := 0
+	for _, p := range leecher.Peers {
+		if p.connected {
+			connected++
+		}
+	}
+	if connected != 1 {
+		t.Errorf("expected a single connection to the seeder, got %d", connected)
+	}
+	if len(leecher.Peers) != len(addrs)-1 {
+		t.Errorf("duplicate peer not dropped, %d peers", len(leecher.Peers))
+	}
+	if dead := leecher.Peers["127.0.0.1:1"]; dead == nil || dead.failures != 1 || !dead.retryAt.After(time.Now()) {
+		t.Error("failed attempt not recorded")
+	}
+}
+
+func Test_StopDisconnectsIncoming(t *testing.T) {
+	seeder, _ := newTestTorrent(t, 16, 20)
+	leecher, _ := newTestTorrent(t, 16, 20)
+	seeder.client.addTorrentIfAbsent(seeder)
+	seeder.client.id = GeneratePeerID()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go seeder.client.handleIncoming(ctx, conn)
+		}
+	}()
+
+	sub := seeder.client.Subscribe(seeder, 64)
+	defer sub.Unsubscribe()
+	seeder.Start(ctx)
+	peer := &Peer{
+		torrent: leecher,
+		Addr:    netip.MustParseAddrPort(listener.Addr().String()),
+	}
+	ended := make(chan error, 1)
+	go func() {
+		ended <- peer.Connect(ctx)
+	}()
+	for connected := false; !connected; {
+		select {
+		case e := <-sub.C:
+			_, connected = e.(PeerConnectedEvent)
+		case <-ctx.Done():
+			t.Fatal("peer not connected")
+		}
+	}
+
+	seeder.Stop()
+	select {
+	case <-ended:
+	case <-time.After(time.Second):
+		t.Fatal("incoming peer not disconnected by Stop")
+	}
+	if err := peer.Connect(ctx); !errors.Is(err, io.EOF) {
+		t.Errorf("stopped torrent accepted a peer: %v", err)
+	}
+	seeder.mu.Lock()
+	defer seeder.mu.Unlock()
+	if len(seeder.Peers) != 0 {
+		t.Errorf("refused peers kept: %v", seeder.Peers)
+	}
+}
@@ -0,0 +1,157 @@
+package torrentclient
+
+import (
+	"context"
+	"encoding/binary"
+	"time"
+)
+
+// pieceDownload is the piece a peer is downloading, blocks are requested in
+// order and copied into data as they arrive
+type pieceDownload struct {
+	index     int
+	data      []byte
+	requested int
+	received  int
+	// pending maps the offsets of the outstanding requests to their length
+	pending map[int]int
+}
+
+// pickPiece returns the index of the next piece to download from a source
+// that has the pieces for which has returns true and marks it as downloading,
+// -1 if the source has nothing we need or all the download slots of the
+// torrent are taken. Pieces with a higher priority are picked first, a
+// snubbed source is given the last of the pieces with the lowest priority so
+// the pieces needed soonest are left to the sources that answer.
+func (torrent *Torrent) pickPiece(has func(index int) bool, snubbed bool) int {
+	torrent.mu.Lock()
+	defer torrent.mu.Unlock()
+	if torrent.downloading >= torrent.client.config.DownloadSlots {
+		return -1
+	}
+	pick := -1
+	for i, p := range torrent.Pieces {
+		if p.Complete || p.downloading || p.priority == PrioritySkip || !has(i) {
+			continue
+		}
+		switch {
+		case pick < 0:
+			pick = i
+		case snubbed && p.priority <= torrent.Pieces[pick].priority:
+			pick = i
+		case !snubbed && p.priority > torrent.Pieces[pick].priority:
+			pick = i
+		}
+	}
+	if pick >= 0 {
+		torrent.Pieces[pick].downloading = true
+		torrent.downloading++
+	}
+	return pick
+}
+
+func (torrent *Torrent) releasePiece(index int) {
+	torrent.mu.Lock()
+	if torrent.Pieces[index].downloading {
+		torrent.Pieces[index].downloading = false
+		torrent.downloading--
+	}
+	torrent.mu.Unlock()
+}
+
+func (peer *Peer) requestBlocks() error {
+	config := peer.torrent.client.config
+	depth := config.RequestQueueDepth
+	if peer.snubbed {
+		depth = 1
+	}
+	for !peer.choked && peer.requests < depth {
+		if peer.piece == nil {
+			index := peer.torrent.pickPiece(peer.hasPiece, peer.snubbed)
+			if index < 0 {
+				return nil
+			}
+			peer.piece = &pieceDownload{
+				index:   index,
+				data:    make([]byte, peer.torrent.pieceLength(index)),
+				pending: make(map[int]int),
+			}
+		}
+		pd := peer.piece
+		if pd.requested >= len(pd.data) {
+			return nil
+		}
+		length := len(pd.data) - pd.requested
+		if length > config.BlockSize {
+			length = config.BlockSize
+		}
+		err := requestMessage(peer.conn, uint32(pd.index), uint32(pd.requested), uint32(length))
+		if err != nil {
+			return err
+		}
+		if peer.requests == 0 {
+			peer.lastBlock = time.Now()
+		}
+		pd.pending[pd.requested] = length
+		pd.requested += length
+		peer.requests++
+	}
+	return nil
+}
+
+func (peer *Peer) releasePiece() {
+	if peer.piece != nil {
+		peer.torrent.releasePiece(peer.piece.index)
+		peer.piece = nil
+	}
+	peer.requests = 0
+}
+
+func (peer *Peer) handlePiece(ctx context.Context, payload []byte) error {
+	if len(payload) < 8 {
+		return ErrInvalidMessage
+	}
+	index := int(binary.BigEndian.Uint32(payload[0:4]))
+	begin := int(binary.BigEndian.Uint32(payload[4:8]))
+	block := payload[8:]
+
+	download, _ := peer.torrent.client.getLimiters(peer.isLocal())
+	err := waitAll(ctx, len(block), peer.torrent.downloadLimiter, download)
+	if err != nil {
+		return err
+	}
+
+	peer.statsGroup().addDownloadedPayload(len(block))
+	peer.touch()
+	peer.lastBlock = time.Now()
+	if peer.snubbed {
+		err := peer.unsnub()
+		if err != nil {
+			return err
+		}
+	}
+
+	// only the blocks we are waiting for are kept, duplicates and blocks
+	// that were not requested are wasted
+	pd := peer.piece
+	if pd == nil || pd.index != index || pd.pending[begin] != len(block) || len(block) == 0 {
+		peer.statsGroup().addWasted(len(block))
+		return nil
+	}
+	delete(pd.pending, begin)
+	copy(pd.data[begin:], block)
+	pd.received += len(block)
+	peer.requests--
+
+	if pd.received >= len(pd.data) {
+		ok, err := peer.torrent.pieceDownloaded(pd.index, pd.data)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			peer.statsGroup().addWasted(len(pd.data))
+		}
+		peer.releasePiece()
+	}
+	return peer.requestBlocks()
+}
@@ -0,0 +1,65 @@
+package torrentclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Errors returned by the client, test for them with errors.Is
+var (
+	ErrInvalidTorrent     = errors.New("invalid torrent")
+	ErrInvalidHandshake   = errors.New("invalid handshake")
+	ErrInfoHashMismatch   = errors.New("info hash mismatch")
+	ErrInvalidMessage     = errors.New("invalid message")
+	ErrTooManyConnections = errors.New("too many connections")
+	ErrDuplicatePeer      = errors.New("duplicate peer")
+	ErrPeerTimeout        = errors.New("peer timed out")
+	ErrTorrentStopped     = errors.New("torrent stopped")
+	ErrTrackerFailure     = errors.New("tracker failure")
+	ErrUnknownTrackerType = errors.New("unknown tracker type")
+)
+
+// TrackerFailureError is returned when a tracker answers with a failure reason
+type TrackerFailureError struct {
+	URL    string
+	Reason string
+}
+
+func (e *TrackerFailureError) Error() string {
+	return fmt.Sprintf("tracker %s: %s", e.URL, e.Reason)
+}
+
+// Unwrap makes errors.Is(err, ErrTrackerFailure) true
+func (e *TrackerFailureError) Unwrap() error {
+	return ErrTrackerFailure
+}
+
+// PeerError wraps an error that occurred on the connection to a peer
+type PeerError struct {
+	Addr string
+	Err  error
+}
+
+func (e *PeerError) Error() string {
+	return fmt.Sprintf("peer %s: %s", e.Addr, e.Err.Error())
+}
+
+// Unwrap returns the underlying error
+func (e *PeerError) Unwrap() error {
+	return e.Err
+}
+
+// contextReader stops reading once the context is done
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (cr *contextReader) Read(p []byte) (int, error) {
+	if err := cr.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return cr.r.Read(p)
+}
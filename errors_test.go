@@ -0,0 +1,83 @@
+package torrentclient
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+func Test_TypedErrors(t *testing.T) {
+	torrent, _ := newTestTorrent(t, 16, 20)
+
+	_, err := torrent.client.AddTorrentFromBytes(context.Background(), []byte("not bencode"))
+	if !errors.Is(err, ErrInvalidTorrent) {
+		t.Errorf("expected ErrInvalidTorrent, got %v", err)
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("d14:failure reason6:no waye"))
+	}))
+	defer server.Close()
+	tracker := NewTracker(server.URL, 0, torrent)
+	_, err = tracker.requestPeers(context.Background(), eventStarted)
+	var tfe *TrackerFailureError
+	if !errors.As(err, &tfe) || tfe.Reason != "no way" || !errors.Is(err, ErrTrackerFailure) {
+		t.Errorf("expected a tracker failure, got %v", err)
+	}
+
+	peer := &Peer{torrent: torrent, Addr: netip.MustParseAddrPort("127.0.0.1:1")}
+	err = peer.Connect(context.Background())
+	var pe *PeerError
+	if !errors.As(err, &pe) || pe.Addr != "127.0.0.1:1" {
+		t.Errorf("expected a peer error, got %v", err)
+	}
+}
+
+func Test_Cancellation(t *testing.T) {
+	torrent, _ := newTestTorrent(t, 16, 20)
+
+	// a peer and a tracker that accept but never answer
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+	addr := listener.Addr().String()
+
+	tests := map[string]func(ctx context.Context) error{
+		"connect": func(ctx context.Context) error {
+			peer := &Peer{torrent: torrent, Addr: netip.MustParseAddrPort(addr)}
+			return peer.Connect(ctx)
+		},
+		"trackers": func(ctx context.Context) error {
+			torrent.Tiers = [][]*Tracker{{NewTracker("http://"+addr+"/announce", 0, torrent)}}
+			return torrent.RequestTrackers(ctx, true)
+		},
+	}
+	for name, fn := range tests {
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(100*time.Millisecond, cancel)
+		start := time.Now()
+		err := fn(ctx)
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("%s: expected context.Canceled, got %v", name, err)
+		}
+		if d := time.Since(start); d > time.Second {
+			t.Errorf("%s: returned %s after the cancellation", name, d)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package torrentclient
+
+import (
+	"sync"
+)
+
+// Event is implemented by every event emitted by the client
+type Event interface {
+	GetTorrent() *Torrent
+}
+
+type torrentEvent struct {
+	torrent *Torrent
+}
+
+// GetTorrent returns the torrent the event belongs to
+func (e torrentEvent) GetTorrent() *Torrent {
+	return e.torrent
+}
+
+// TorrentAddedEvent is emitted when a torrent is added to the client
+type TorrentAddedEvent struct {
+	torrentEvent
+}
+
+// StateChangedEvent is emitted when the state of a torrent changes
+type StateChangedEvent struct {
+	torrentEvent
+	Old TorrentState
+	New TorrentState
+}
+
+// PieceVerifiedEvent is emitted when a piece passes the hash check
+type PieceVerifiedEvent struct {
+	torrentEvent
+	Index int
+}
+
+// PieceFailedEvent is emitted when a piece fails the hash check
+type PieceFailedEvent struct {
+	torrentEvent
+	Index int
+}
+
+// PeerConnectedEvent is emitted when the handshake with a peer completes
+type PeerConnectedEvent struct {
+	torrentEvent
+	Peer *Peer
+}
+
+// PeerDisconnectedEvent is emitted when the connection to a peer is closed
+type PeerDisconnectedEvent struct {
+	torrentEvent
+	Peer *Peer
+	Err  error
+}
+
+// TrackerAnnouncedEvent is emitted when a tracker answers an announce
+type TrackerAnnouncedEvent struct {
+	torrentEvent
+	Tracker *Tracker
+	Peers   int
+}
+
+// TrackerErrorEvent is emitted when an announce to a tracker fails
+type TrackerErrorEvent struct {
+	torrentEvent
+	Tracker *Tracker
+	Err     error
+}
+
+// DownloadCompleteEvent is emitted when all the wanted pieces of a torrent
+// are verified, the pieces of skipped files may be missing
+type DownloadCompleteEvent struct {
+	torrentEvent
+}
+
+// Subscription receives events from the client on C
+type Subscription struct {
+	C       <-chan Event
+	c       chan Event
+	torrent *Torrent
+	bus     *eventBus
+	once    sync.Once
+}
+
+type eventBus struct {
+	mu   sync.RWMutex
+	subs map[*Subscription]struct{}
+}
+
+func newEventBus() *eventBus {
+	return &eventBus{
+		subs: make(map[*Subscription]struct{}),
+	}
+}
+
+// Subscribe returns a subscription to the events of the client. If torrent is
+// not nil only the events of that torrent are delivered. Events are dropped
+// when the buffer of the subscription is full so a slow subscriber never
+// blocks the client.
+func (tc *TorrentClient) Subscribe(torrent *Torrent, buffer int) *Subscription {
+	c := make(chan Event, buffer)
+	sub := &Subscription{
+		C:       c,
+		c:       c,
+		torrent: torrent,
+		bus:     tc.events,
+	}
+	tc.events.mu.Lock()
+	tc.events.subs[sub] = struct{}{}
+	tc.events.mu.Unlock()
+	return sub
+}
+
+// Unsubscribe stops the delivery of events and closes C
+func (sub *Subscription) Unsubscribe() {
+	sub.once.Do(func() {
+		sub.bus.mu.Lock()
+		delete(sub.bus.subs, sub)
+		sub.bus.mu.Unlock()
+		close(sub.c)
+	})
+}
+
+func (bus *eventBus) emit(e Event) {
+	bus.mu.RLock()
+	defer bus.mu.RUnlock()
+	for sub := range bus.subs {
+		if sub.torrent != nil && sub.torrent != e.GetTorrent() {
+			continue
+		}
+		select {
+		case sub.c <- e:
+		default:
+		}
+	}
+}
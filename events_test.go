@@ -0,0 +1,66 @@
+package torrentclient
+
+import (
+	"testing"
+)
+
+func Test_EventFiltering(t *testing.T) {
+	client := NewTorrentClient(GeneratePeerID(), 6881)
+	a := newTorrent(client)
+	b := newTorrent(client)
+
+	all := client.Subscribe(nil, 8)
+	defer all.Unsubscribe()
+	onlyA := client.Subscribe(a, 8)
+	defer onlyA.Unsubscribe()
+
+	client.events.emit(PieceVerifiedEvent{torrentEvent{a}, 1})
+	client.events.emit(PieceVerifiedEvent{torrentEvent{b}, 2})
+
+	if len(all.C) != 2 {
+		t.Errorf("expected 2 events for the client, got %d", len(all.C))
+	}
+	if len(onlyA.C) != 1 {
+		t.Fatalf("expected 1 event for the torrent, got %d", len(onlyA.C))
+	}
+	e := (<-onlyA.C).(PieceVerifiedEvent)
+	if e.GetTorrent() != a || e.Index != 1 {
+		t.Error("event of another torrent delivered")
+	}
+}
+
+func Test_Unsubscribe(t *testing.T) {
+	client := NewTorrentClient(GeneratePeerID(), 6881)
+	torrent := newTorrent(client)
+	sub := client.Subscribe(nil, 8)
+	sub.Unsubscribe()
+	// a second call does nothing
+	sub.Unsubscribe()
+
+	if _, ok := <-sub.C; ok {
+		t.Error("channel not closed")
+	}
+	// emitting after unsubscribing must not panic on the closed channel
+	client.events.emit(TorrentAddedEvent{torrentEvent{torrent}})
+}
+
+func Test_FullSubscriber(t *testing.T) {
+	client := NewTorrentClient(GeneratePeerID(), 6881)
+	torrent := newTorrent(client)
+	slow := client.Subscribe(nil, 1)
+	defer slow.Unsubscribe()
+	fast := client.Subscribe(nil, 8)
+	defer fast.Unsubscribe()
+
+	// emit never blocks, the events a full subscriber has no room for are
+	// dropped for it only
+	for i := 0; i < 3; i++ {
+		client.events.emit(PieceVerifiedEvent{torrentEvent{torrent}, i})
+	}
+	if len(slow.C) != 1 || (<-slow.C).(PieceVerifiedEvent).Index != 0 {
+		t.Error("full subscriber did not keep the first event only")
+	}
+	if len(fast.C) != 3 {
+		t.Errorf("expected 3 events, got %d", len(fast.C))
+	}
+}
@@ -0,0 +1,8 @@
+//go:build !windows
+
+package torrentclient
+
+// setHidden does nothing, files are hidden by their name outside of Windows
+func setHidden(path string) error {
+	return nil
+}
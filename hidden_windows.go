@@ -0,0 +1,18 @@
+//go:build windows
+
+package torrentclient
+
+import "syscall"
+
+// setHidden sets the hidden attribute of the file
+func setHidden(path string) error {
+	p, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return err
+	}
+	attrs, err := syscall.GetFileAttributes(p)
+	if err != nil {
+		return err
+	}
+	return syscall.SetFileAttributes(p, attrs|syscall.FILE_ATTRIBUTE_HIDDEN)
+}
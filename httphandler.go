@@ -0,0 +1,121 @@
+package torrentclient
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"html"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// HTTPHandler returns a handler serving the files of the torrents of the
+// client at /<hex info hash>/<file path>
+func (tc *TorrentClient) HTTPHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p := strings.TrimPrefix(r.URL.Path, "/")
+		hash, rest, _ := strings.Cut(p, "/")
+		infoHash, err := hex.DecodeString(hash)
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		tc.mu.RLock()
+		torrent := tc.torrents[string(infoHash)]
+		tc.mu.RUnlock()
+		if torrent == nil {
+			http.NotFound(w, r)
+			return
+		}
+		torrent.serveHTTP(w, r, rest)
+	})
+}
+
+// HTTPHandler returns a handler serving the files of the torrent by their
+// path, with Range requests and directory listings
+func (torrent *Torrent) HTTPHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		torrent.serveHTTP(w, r, strings.TrimPrefix(r.URL.Path, "/"))
+	})
+}
+
+func (torrent *Torrent) serveHTTP(w http.ResponseWriter, r *http.Request, p string) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	p = strings.Trim(path.Clean("/"+p), "/")
+
+	for i, f := range torrent.Files {
+		if f.Path != p || f.IsPad() {
+			continue
+		}
+		reader, err := torrent.OpenFile(i)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer reader.Close()
+		// a Read waiting for a missing piece returns once the client is gone
+		stop := context.AfterFunc(r.Context(), func() {
+			reader.Close()
+		})
+		defer stop()
+		contentType := mime.TypeByExtension(path.Ext(p))
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+		w.Header().Set("Content-Type", contentType)
+		http.ServeContent(w, r, path.Base(p), time.Time{}, reader)
+		return
+	}
+
+	entries := torrent.listDir(p)
+	if len(entries) == 0 {
+		http.NotFound(w, r)
+		return
+	}
+	if !strings.HasSuffix(r.URL.Path, "/") {
+		http.Redirect(w, r, path.Base(r.URL.Path)+"/", http.StatusMovedPermanently)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprintf(w, "<pre>\n")
+	for _, e := range entries {
+		u := url.URL{Path: e}
+		fmt.Fprintf(w, "<a href=\"%s\">%s</a>\n", u.String(), html.EscapeString(e))
+	}
+	fmt.Fprintf(w, "</pre>\n")
+}
+
+// listDir returns the names of the files and directories in the directory
+// dir of the torrent, directories end with a slash
+func (torrent *Torrent) listDir(dir string) []string {
+	prefix := ""
+	if dir != "" {
+		prefix = dir + "/"
+	}
+	seen := make(map[string]bool)
+	entries := make([]string, 0)
+	for _, f := range torrent.Files {
+		if !strings.HasPrefix(f.Path, prefix) || f.IsPad() {
+			continue
+		}
+		name, rest, isDir := strings.Cut(strings.TrimPrefix(f.Path, prefix), "/")
+		if isDir && rest != "" {
+			name += "/"
+		}
+		if !seen[name] {
+			seen[name] = true
+			entries = append(entries, name)
+		}
+	}
+	sort.Strings(entries)
+	return entries
+}
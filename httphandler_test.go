@@ -0,0 +1,83 @@
+package torrentclient
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_HTTPHandler(t *testing.T) {
+	torrent, data := newTestTorrent(t, 16, 20, 30)
+	for i := range torrent.Pieces {
+		_, err := torrent.pieceDownloaded(i, data[i*16:min((i+1)*16, len(data))])
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	server := httptest.NewServer(torrent.HTTPHandler())
+	defer server.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, server.URL+"/dir/b", nil)
+	req.Header.Set("Range", "bytes=10-19")
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := io.ReadAll(res.Body)
+	res.Body.Close()
+	if res.StatusCode != http.StatusPartialContent || string(b) != string(data[30:40]) {
+		t.Errorf("unexpected range response %d", res.StatusCode)
+	}
+
+	res, err = http.Get(server.URL + "/dir/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ = io.ReadAll(res.Body)
+	res.Body.Close()
+	if !strings.Contains(string(b), `href="a"`) || !strings.Contains(string(b), `href="b"`) {
+		t.Errorf("unexpected directory listing %q", b)
+	}
+}
+
+func Test_HTTPHandlerCancel(t *testing.T) {
+	torrent, _ := newTestTorrent(t, 16, 20, 30)
+	server := httptest.NewServer(torrent.HTTPHandler())
+	defer server.Close()
+
+	// no piece is complete, the request waits until it is canceled
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"/dir/a", nil)
+	res, err := http.DefaultClient.Do(req)
+	if err == nil {
+		io.ReadAll(res.Body)
+		res.Body.Close()
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		torrent.mu.Lock()
+		readers := len(torrent.readers)
+		priority := torrent.Pieces[0].priority
+		torrent.mu.Unlock()
+		if readers == 0 && priority != priorityReadahead {
+			return
+		}
+		if time.Now().After(deadline) {
+			// unblock the handler so the server can be closed
+			torrent.mu.Lock()
+			for r := range torrent.readers {
+				r.closed = true
+			}
+			torrent.pieceCond.Broadcast()
+			torrent.mu.Unlock()
+			t.Fatalf("reader not closed after the request was canceled, %d readers", readers)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,138 @@
+package torrentclient
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	bencode "github.com/tharindu96/bencode-go"
+)
+
+// HTTPSeed is a server answering piece requests for a torrent (BEP 17)
+type HTTPSeed struct {
+	torrent *Torrent
+	URL     string
+	stats   transferStats
+	// partial holds the data received of a piece whose request failed, so a
+	// retry only asks for the rest
+	partial *pieceDownload
+}
+
+// Run downloads pieces from the HTTP seed until the context is done or every
+// wanted piece is complete. The retry time sent by a busy server is honored,
+// other failures are retried with an exponential backoff.
+func (hs *HTTPSeed) Run(ctx context.Context) error {
+	return runPieceSource(ctx, hs.torrent, hs)
+}
+
+// Stats returns the transfer statistics of the HTTP seed
+func (hs *HTTPSeed) Stats() Stats {
+	return hs.stats.get()
+}
+
+func (hs *HTTPSeed) statsGroup() statsGroup {
+	return statsGroup{&hs.stats, &hs.torrent.stats, &hs.torrent.client.stats}
+}
+
+// fetchPiece requests the piece at index from the server, the part received
+// by a failed request of the same piece is not requested again
+func (hs *HTTPSeed) fetchPiece(ctx context.Context, index int) ([]byte, error) {
+	data, err := hs.requestPiece(ctx, index)
+	if err != nil {
+		return nil, fmt.Errorf("http seed %s: %w", hs.URL, err)
+	}
+	return data, nil
+}
+
+func (hs *HTTPSeed) requestPiece(ctx context.Context, index int) ([]byte, error) {
+	torrent := hs.torrent
+	pd := hs.partial
+	if pd == nil || pd.index != index {
+		pd = &pieceDownload{
+			index: index,
+			data:  make([]byte, torrent.pieceLength(index)),
+		}
+		hs.partial = pd
+	}
+
+	vals := url.Values{}
+	vals.Set("info_hash", string(torrent.InfoHash))
+	vals.Set("piece", strconv.Itoa(index))
+	vals.Set("ranges", fmt.Sprintf("%d-%d", pd.received, len(pd.data)-1))
+	sep := "?"
+	if strings.Contains(hs.URL, "?") {
+		sep = "&"
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, hs.URL+sep+vals.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := torrent.fetchSeed(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+
+	if res.StatusCode == http.StatusServiceUnavailable {
+		return nil, &retryAfterError{after: parseRetryAfter(res.Response)}
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", res.Status)
+	}
+
+	n, err := res.read(pd.data[pd.received:], hs.statsGroup())
+	pd.received += n
+	if err != nil {
+		return nil, err
+	}
+	hs.partial = nil
+	return pd.data, nil
+}
+
+// parseRetryAfter reads the number of seconds a busy server asks to wait,
+// from the body as BEP 17 specifies or from the Retry-After header
+func parseRetryAfter(res *http.Response) time.Duration {
+	body, _ := io.ReadAll(io.LimitReader(res.Body, 32))
+	secs, err := strconv.Atoi(strings.TrimSpace(string(body)))
+	if err != nil {
+		secs, err = strconv.Atoi(res.Header.Get("Retry-After"))
+	}
+	if err != nil || secs <= 0 {
+		return webSeedMinBackoff
+	}
+	return time.Duration(secs) * time.Second
+}
+
+func getHTTPSeeds(tordict *bencode.BDict, torrent *Torrent) ([]*HTTPSeed, error) {
+	node := tordict.Get("httpseeds")
+	seeds := make([]*HTTPSeed, 0)
+	if node == nil {
+		return seeds, nil
+	}
+	list, err := node.GetList()
+	if err != nil {
+		return nil, err
+	}
+	for _, n := range list {
+		s, err := n.GetString()
+		if err != nil {
+			return nil, err
+		}
+		u := s.ToString()
+		if !strings.HasPrefix(u, "http://") && !strings.HasPrefix(u, "https://") {
+			continue
+		}
+		seeds = append(seeds, &HTTPSeed{
+			torrent: torrent,
+			URL:     u,
+		})
+	}
+	return seeds, nil
+}
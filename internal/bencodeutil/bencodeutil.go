@@ -0,0 +1,48 @@
+/*
+Package bencodeutil bencodes values with the keys of their dictionaries
+sorted, as bencoding requires. BEncode builds dictionaries in map order.
+*/
+package bencodeutil
+
+import (
+	"sort"
+
+	bencode "github.com/tharindu96/bencode-go"
+)
+
+// Marshal bencodes the value
+func Marshal(val interface{}) ([]byte, error) {
+	node, err := bencode.BEncode(val)
+	if err != nil {
+		return nil, err
+	}
+	return Encode(node)
+}
+
+// Encode bencodes the node, the keys of its dictionaries are sorted in place
+func Encode(node *bencode.BNode) ([]byte, error) {
+	sortKeys(node)
+	s, err := node.GetBencode()
+	if err != nil {
+		return nil, err
+	}
+	return []byte(s), nil
+}
+
+func sortKeys(node *bencode.BNode) {
+	switch node.Type {
+	case bencode.BencodeDict:
+		dict, _ := node.GetDict()
+		sort.SliceStable(dict, func(i, j int) bool {
+			return dict[i].Key < dict[j].Key
+		})
+		for _, d := range dict {
+			sortKeys(d.Value)
+		}
+	case bencode.BencodeList:
+		list, _ := node.GetList()
+		for _, n := range list {
+			sortKeys(n)
+		}
+	}
+}
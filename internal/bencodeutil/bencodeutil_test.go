@@ -0,0 +1,19 @@
+package bencodeutil
+
+import (
+	"testing"
+)
+
+func Test_Marshal(t *testing.T) {
+	b, err := Marshal(map[string]interface{}{
+		"z": 1,
+		"a": []interface{}{map[string]interface{}{"y": "b", "x": "a"}},
+		"m": "s",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "d1:ald1:x1:a1:y1:bee1:m1:s1:zi1ee" {
+		t.Errorf("keys not sorted: %s", b)
+	}
+}
@@ -0,0 +1,57 @@
+package torrentclient
+
+import (
+	"time"
+)
+
+// tickInterval returns how often the timers of the peer are checked, often
+// enough for the keep-alive and snub timeouts to be met closely
+func (peer *Peer) tickInterval() time.Duration {
+	config := peer.torrent.client.config
+	return min(config.KeepAliveInterval, config.SnubTimeout) / 4
+}
+
+// tick sends a keep-alive when nothing was sent for the keep-alive interval
+// and snubs the peer if it did not send a requested block for the snub
+// timeout
+func (peer *Peer) tick(now time.Time) error {
+	config := peer.torrent.client.config
+	if !peer.snubbed && peer.requests > 0 && now.Sub(peer.lastBlock) >= config.SnubTimeout {
+		err := peer.snub()
+		if err != nil {
+			return err
+		}
+	}
+	if now.Sub(peer.lastSent) >= config.KeepAliveInterval {
+		return keepaliveMessage(peer.conn)
+	}
+	return nil
+}
+
+// snub marks the peer as snubbed, its piece is left to the other peers, it is
+// choked and only one block at a time is requested from it until it sends a
+// block again
+func (peer *Peer) snub() error {
+	peer.snubbed = true
+	peer.releasePiece()
+	if !peer.choking {
+		peer.releaseUploadSlot()
+		err := chokeMessage(peer.conn)
+		if err != nil {
+			return err
+		}
+	}
+	// a block stays requested so the peer can be unsnubbed
+	return peer.requestBlocks()
+}
+
+// unsnub clears the snub of the peer and unchokes it again if it is
+// interested and an upload slot is free
+func (peer *Peer) unsnub() error {
+	peer.snubbed = false
+	if peer.interested && peer.choking && peer.torrent.acquireUploadSlot() {
+		peer.choking = false
+		return unchokeMessage(peer.conn)
+	}
+	return nil
+}
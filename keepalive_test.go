@@ -0,0 +1,139 @@
+package torrentclient
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+func Test_KeepAliveAndSnub(t *testing.T) {
+	leecher, _ := newTestTorrent(t, 16, 20, 30)
+	config := leecher.client.config
+	config.KeepAliveInterval = 100 * time.Millisecond
+	config.SnubTimeout = 200 * time.Millisecond
+	config.PeerTimeout = 600 * time.Millisecond
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	// the remote peer has every piece, unchokes us and then never answers
+	msgs := make(chan *message, 64)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		_, _, _, err = readHandshake(conn)
+		if err != nil {
+			return
+		}
+		writeHandshake(conn, leecher.InfoHash, "remote")
+		writeMessage(conn, &message{ID: msgBitfield, Payload: []byte{0xf0}})
+		unchokeMessage(conn)
+		for {
+			msg, err := readMessage(conn)
+			if err != nil {
+				close(msgs)
+				return
+			}
+			msgs <- msg
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	peer := &Peer{
+		torrent: leecher,
+		Addr:    netip.MustParseAddrPort(listener.Addr().String()),
+	}
+	start := time.Now()
+	err = peer.Connect(ctx)
+	if !errors.Is(err, ErrPeerTimeout) {
+		t.Fatalf("expected a timeout, got %v", err)
+	}
+	if d := time.Since(start); d < config.PeerTimeout || d > 2*time.Second {
+		t.Errorf("disconnected after %s", d)
+	}
+	if !peer.snubbed {
+		t.Error("peer not snubbed")
+	}
+
+	keepalives := 0
+	requests := make([]int, 0)
+	for msg := range msgs {
+		switch {
+		case msg == nil:
+			keepalives++
+		case msg.ID == msgRequest:
+			requests = append(requests, int(binary.BigEndian.Uint32(msg.Payload[0:4])))
+		}
+	}
+	if keepalives == 0 {
+		t.Error("no keep-alive sent")
+	}
+	// once snubbed a single block of the last piece is requested
+	if len(requests) != 2 || requests[0] != 0 || requests[1] != len(leecher.Pieces)-1 {
+		t.Errorf("unexpected requests %v", requests)
+	}
+}
+
+func Test_KeepAliveMessage(t *testing.T) {
+	a, b := net.Pipe()
+	defer a.Close()
+	defer b.Close()
+	go keepaliveMessage(a)
+	buff := make([]byte, 4)
+	b.SetReadDeadline(time.Now().Add(time.Second))
+	n, err := b.Read(buff)
+	if err != nil || n != 4 || string(buff) != "\x00\x00\x00\x00" {
+		t.Fatal(n, err, buff)
+	}
+}
+
+func Test_ReconnectResetsState(t *testing.T) {
+	leecher, _ := newTestTorrent(t, 16, 20, 30)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	// the remote peer completes the handshake and hangs up
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		if _, _, _, err := readHandshake(conn); err == nil {
+			writeHandshake(conn, leecher.InfoHash, "remote")
+		}
+	}()
+
+	// the state left by a previous connection
+	peer := &Peer{
+		torrent:    leecher,
+		Addr:       netip.MustParseAddrPort(listener.Addr().String()),
+		bitfield:   []byte{0xf0},
+		interested: true,
+		requests:   3,
+		lastBlock:  time.Now(),
+		snubbed:    true,
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := peer.Connect(ctx); err == nil {
+		t.Fatal("expected the connection to end")
+	}
+	if peer.snubbed || peer.interested || peer.requests != 0 || peer.bitfield != nil || !peer.lastBlock.IsZero() {
+		t.Errorf("state of the previous connection kept: %+v", peer)
+	}
+}
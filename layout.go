@@ -0,0 +1,83 @@
+package torrentclient
+
+import "sort"
+
+// FileRange is the part of a file covered by a piece
+type FileRange struct {
+	File   int
+	Offset int64
+	Length int64
+}
+
+// GetPieceLength returns the exact length of the piece at index, the last
+// piece is usually shorter than the others
+func (torrent *Torrent) GetPieceLength(index int) uint {
+	return torrent.pieceLength(index)
+}
+
+// PieceFileRanges returns the parts of the files the piece at index covers,
+// in torrent order
+func (torrent *Torrent) PieceFileRanges(index int) []FileRange {
+	return torrent.fileRanges(torrent.pieceOffset(index), int64(torrent.pieceLength(index)))
+}
+
+// FilePieceRange returns the indexes of the first and the last piece the file
+// at index spans, last is lower than first for an empty file
+func (torrent *Torrent) FilePieceRange(index int) (int, int) {
+	start := torrent.fileOffset(index)
+	pieceLength := int64(torrent.PieceLength)
+	first := int(start / pieceLength)
+	length := int64(torrent.Files[index].Length)
+	if length == 0 {
+		return first, first - 1
+	}
+	return first, int((start + length - 1) / pieceLength)
+}
+
+// setFiles sets the files of the torrent and the offsets they start at, the
+// offsets let a torrent range be mapped to its files with a binary search
+func (torrent *Torrent) setFiles(files []*File) {
+	offsets := make([]int64, len(files)+1)
+	for i, f := range files {
+		offsets[i+1] = offsets[i] + int64(f.Length)
+	}
+	torrent.Files = files
+	torrent.fileOffsets = offsets
+}
+
+// totalLength returns the sum of the lengths of the files
+func (torrent *Torrent) totalLength() int64 {
+	if len(torrent.fileOffsets) == 0 {
+		return 0
+	}
+	return torrent.fileOffsets[len(torrent.fileOffsets)-1]
+}
+
+// fileOffset returns the torrent offset the file at index starts at
+func (torrent *Torrent) fileOffset(index int) int64 {
+	return torrent.fileOffsets[index]
+}
+
+// fileRanges returns the parts of the files covered by length bytes at the
+// torrent offset off, empty files are left out
+func (torrent *Torrent) fileRanges(off int64, length int64) []FileRange {
+	ranges := make([]FileRange, 0, 1)
+	end := off + length
+	// the first file that ends after off
+	first := sort.Search(len(torrent.Files), func(i int) bool {
+		return torrent.fileOffsets[i+1] > off
+	})
+	for i := first; i < len(torrent.Files) && torrent.fileOffsets[i] < end; i++ {
+		fileStart, fileEnd := torrent.fileOffsets[i], torrent.fileOffsets[i+1]
+		if fileEnd == fileStart {
+			continue
+		}
+		start := max(off, fileStart)
+		ranges = append(ranges, FileRange{
+			File:   i,
+			Offset: start - fileStart,
+			Length: min(end, fileEnd) - start,
+		})
+	}
+	return ranges
+}
@@ -0,0 +1,49 @@
+package torrentclient
+
+import (
+	"reflect"
+	"testing"
+)
+
+func Test_Layout(t *testing.T) {
+	torrent, _ := newTestTorrent(t, 16, 10, 0, 30)
+
+	if torrent.GetSize() != 40 || len(torrent.Pieces) != 3 || torrent.GetPieceLength(2) != 8 {
+		t.Errorf("unexpected size %d, pieces %d, last piece %d", torrent.GetSize(), len(torrent.Pieces), torrent.GetPieceLength(2))
+	}
+	ranges := torrent.PieceFileRanges(0)
+	want := []FileRange{{File: 0, Offset: 0, Length: 10}, {File: 2, Offset: 0, Length: 6}}
+	if !reflect.DeepEqual(ranges, want) {
+		t.Errorf("unexpected ranges %v", ranges)
+	}
+	if ranges := torrent.PieceFileRanges(2); !reflect.DeepEqual(ranges, []FileRange{{File: 2, Offset: 22, Length: 8}}) {
+		t.Errorf("unexpected ranges of the last piece %v", ranges)
+	}
+	if first, last := torrent.FilePieceRange(2); first != 0 || last != 2 {
+		t.Errorf("unexpected pieces of file 2 %d-%d", first, last)
+	}
+	if first, last := torrent.FilePieceRange(1); last >= first {
+		t.Errorf("empty file spans pieces %d-%d", first, last)
+	}
+	if left := torrent.Stats().Left; left != 40 {
+		t.Errorf("unexpected left %d", left)
+	}
+}
+
+func Test_LayoutManyFiles(t *testing.T) {
+	torrent, _ := newTestTorrent(t, 16, 0, 12, 3, 0, 5, 4, 0, 20)
+
+	ranges := torrent.PieceFileRanges(1)
+	want := []FileRange{{File: 4, Offset: 1, Length: 4}, {File: 5, Offset: 0, Length: 4}, {File: 7, Offset: 0, Length: 8}}
+	if !reflect.DeepEqual(ranges, want) {
+		t.Errorf("unexpected ranges %v", ranges)
+	}
+	ranges = torrent.PieceFileRanges(0)
+	want = []FileRange{{File: 1, Offset: 0, Length: 12}, {File: 2, Offset: 0, Length: 3}, {File: 4, Offset: 0, Length: 1}}
+	if !reflect.DeepEqual(ranges, want) {
+		t.Errorf("unexpected ranges of the first piece %v", ranges)
+	}
+	if off := torrent.fileOffset(7); off != 24 {
+		t.Errorf("unexpected offset of file 7 %d", off)
+	}
+}
@@ -0,0 +1,81 @@
+package torrentclient
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"time"
+)
+
+// Listen accepts peer connections on the listen address of the config until
+// the context is done. An address without a host, such as ":6881", accepts
+// both IPv4 and IPv6 peers.
+func (tc *TorrentClient) Listen(ctx context.Context) error {
+	var lc net.ListenConfig
+	listener, err := lc.Listen(ctx, "tcp", tc.config.ListenAddr)
+	if err != nil {
+		return err
+	}
+	stop := context.AfterFunc(ctx, func() {
+		listener.Close()
+	})
+	defer stop()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return err
+		}
+		go tc.handleIncoming(ctx, conn)
+	}
+}
+
+// handleIncoming reads the handshake of an incoming connection to find the
+// torrent it is for, answers it and exchanges messages with the peer. Peers
+// of stopped torrents are refused.
+func (tc *TorrentClient) handleIncoming(ctx context.Context, conn net.Conn) {
+	conn.SetDeadline(time.Now().Add(tc.config.HandshakeTimeout))
+	infoHash, id, reserved, err := readHandshake(conn)
+	if err != nil {
+		conn.Close()
+		return
+	}
+
+	tc.mu.RLock()
+	torrent := tc.torrents[string(infoHash)]
+	tc.mu.RUnlock()
+	addr, ok := conn.RemoteAddr().(*net.TCPAddr)
+	if torrent == nil || !ok || !torrent.isStarted() {
+		conn.Close()
+		return
+	}
+	if !tc.acquireConnection(torrent) {
+		conn.Close()
+		return
+	}
+	defer tc.releaseConnection(torrent)
+
+	ap := addr.AddrPort()
+	peer := &Peer{
+		torrent:  torrent,
+		ID:       id,
+		Addr:     netip.AddrPortFrom(ap.Addr().Unmap(), ap.Port()),
+		source:   sourceIncoming,
+		extended: supportsExtensions(reserved),
+	}
+	torrent.mu.Lock()
+	torrent.Peers[peer.Addr.String()] = peer
+	torrent.mu.Unlock()
+
+	peer.statsGroup().addDownloaded(handshakeLength)
+	peer.serve(ctx, conn, func() error {
+		return writeHandshake(peer.conn, torrent.InfoHash, tc.GetID())
+	})
+	// the peer is also forgotten when it was refused
+	torrent.mu.Lock()
+	torrent.removePeer(peer)
+	torrent.mu.Unlock()
+}
@@ -0,0 +1,454 @@
+package torrentclient
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	bencode "github.com/tharindu96/bencode-go"
+	"github.com/tharindu96/torrentclient-go/internal/bencodeutil"
+)
+
+// MetaInfo is the content of a .torrent file. Keys it does not know are kept
+// so encoding it gives back the original file.
+type MetaInfo struct {
+	Announce     string
+	AnnounceList [][]string
+	Comment      string
+	CreatedBy    string
+	CreationDate time.Time
+	Encoding     string
+	// URLList holds the web seeds (BEP 19), HTTPSeeds the HTTP seeds (BEP 17)
+	URLList   []string
+	HTTPSeeds []string
+	// Nodes are DHT bootstrap nodes as host:port (BEP 5)
+	Nodes []string
+	Info  InfoDict
+	// InfoBytes is the bencoded info dict as found in the file, the info hash
+	// is its SHA-1. It is encoded as is, Info is only encoded when it is nil.
+	InfoBytes []byte
+
+	extra bencode.BDict
+	// urlListString keeps a url-list given as a single string one
+	urlListString bool
+}
+
+// InfoDict is the info dictionary of a .torrent file, Length and MD5Sum are
+// set for single file torrents and Files for multi file torrents
+type InfoDict struct {
+	Name        string
+	PieceLength int64
+	Pieces      []byte
+	Private     bool
+	Source      string
+	Length      int64
+	MD5Sum      string
+	Attr        string
+	Files       []FileInfo
+}
+
+// FileInfo is a file of a multi file torrent
+type FileInfo struct {
+	Length      int64
+	Path        []string
+	MD5Sum      string
+	Attr        string
+	SymlinkPath []string
+}
+
+var metaInfoKeys = []string{
+	"announce", "announce-list", "comment", "created by", "creation date",
+	"encoding", "url-list", "httpseeds", "nodes", "info",
+}
+
+// ParseMetaInfo reads a .torrent file
+func ParseMetaInfo(r io.Reader) (*MetaInfo, error) {
+	node, err := bencode.BRead(bufio.NewReader(r))
+	if err != nil {
+		return nil, err
+	}
+	dict, err := node.GetDict()
+	if err != nil {
+		return nil, err
+	}
+	return parseMetaInfo(&dict)
+}
+
+func parseMetaInfo(dict *bencode.BDict) (*MetaInfo, error) {
+	mi := &MetaInfo{
+		Announce:  getDictString(dict, "announce"),
+		Comment:   getDictString(dict, "comment"),
+		CreatedBy: getDictString(dict, "created by"),
+		Encoding:  getDictString(dict, "encoding"),
+		URLList:   getDictStrings(dict, "url-list"),
+		HTTPSeeds: getDictStrings(dict, "httpseeds"),
+	}
+	if node := dict.Get("url-list"); node != nil {
+		mi.urlListString = node.Type == bencode.BencodeString
+	}
+	if date := getDictInt(dict, "creation date"); date != 0 {
+		mi.CreationDate = time.Unix(int64(date), 0)
+	}
+	if node := dict.Get("announce-list"); node != nil {
+		tiers, _ := node.GetList()
+		for _, t := range tiers {
+			tier := getStrings(t)
+			if len(tier) > 0 {
+				mi.AnnounceList = append(mi.AnnounceList, tier)
+			}
+		}
+	}
+	if node := dict.Get("nodes"); node != nil {
+		nodes, _ := node.GetList()
+		for _, n := range nodes {
+			pair, err := n.GetList()
+			if err != nil || len(pair) != 2 {
+				continue
+			}
+			host, err1 := pair[0].GetString()
+			port, err2 := pair[1].GetInteger()
+			if err1 != nil || err2 != nil {
+				continue
+			}
+			mi.Nodes = append(mi.Nodes, net.JoinHostPort(host.ToString(), strconv.Itoa(port.ToInt())))
+		}
+	}
+
+	infoNode := dict.Get("info")
+	if infoNode == nil {
+		return nil, errors.New("info entry not in the torrent file")
+	}
+	infoDict, err := infoNode.GetDict()
+	if err != nil {
+		return nil, err
+	}
+	infoBencode, err := infoNode.GetBencode()
+	if err != nil {
+		return nil, err
+	}
+	mi.InfoBytes = []byte(infoBencode)
+	mi.Info = parseInfoDict(&infoDict)
+
+	for _, d := range *dict {
+		if !stringInStringList(d.Key, metaInfoKeys) {
+			mi.extra = append(mi.extra, d)
+		}
+	}
+	return mi, nil
+}
+
+func parseInfoDict(dict *bencode.BDict) InfoDict {
+	info := InfoDict{
+		Name:        getDictString(dict, "name"),
+		PieceLength: int64(getDictInt(dict, "piece length")),
+		Pieces:      []byte(getDictString(dict, "pieces")),
+		Private:     getDictInt(dict, "private") == 1,
+		Source:      getDictString(dict, "source"),
+		Length:      int64(getDictInt(dict, "length")),
+		MD5Sum:      getDictString(dict, "md5sum"),
+		Attr:        getDictString(dict, "attr"),
+	}
+	if node := dict.Get("files"); node != nil {
+		// an empty list still makes a multi-file torrent, Validate rejects it
+		info.Files = []FileInfo{}
+		files, _ := node.GetList()
+		for _, f := range files {
+			fDict, err := f.GetDict()
+			if err != nil {
+				continue
+			}
+			info.Files = append(info.Files, FileInfo{
+				Length:      int64(getDictInt(&fDict, "length")),
+				Path:        getDictStrings(&fDict, "path"),
+				MD5Sum:      getDictString(&fDict, "md5sum"),
+				Attr:        getDictString(&fDict, "attr"),
+				SymlinkPath: getDictStrings(&fDict, "symlink path"),
+			})
+		}
+	}
+	return info
+}
+
+// InfoHash returns the SHA-1 of the info dict
+func (mi *MetaInfo) InfoHash() ([]byte, error) {
+	b, err := mi.infoBytes()
+	if err != nil {
+		return nil, err
+	}
+	hash := sha1.Sum(b)
+	return hash[:], nil
+}
+
+// Encode writes the metainfo as a .torrent file
+func (mi *MetaInfo) Encode(w io.Writer) error {
+	b, err := mi.Bytes()
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(b)
+	return err
+}
+
+// Bytes returns the metainfo encoded as a .torrent file
+func (mi *MetaInfo) Bytes() ([]byte, error) {
+	val := make(map[string]interface{})
+	setString(val, "announce", mi.Announce)
+	setString(val, "comment", mi.Comment)
+	setString(val, "created by", mi.CreatedBy)
+	setString(val, "encoding", mi.Encoding)
+	if mi.urlListString && len(mi.URLList) == 1 {
+		setString(val, "url-list", mi.URLList[0])
+	} else {
+		setStrings(val, "url-list", mi.URLList)
+	}
+	setStrings(val, "httpseeds", mi.HTTPSeeds)
+	if !mi.CreationDate.IsZero() {
+		val["creation date"] = int(mi.CreationDate.Unix())
+	}
+	if len(mi.AnnounceList) > 0 {
+		tiers := make([]interface{}, 0, len(mi.AnnounceList))
+		for _, tier := range mi.AnnounceList {
+			tiers = append(tiers, toList(tier))
+		}
+		val["announce-list"] = tiers
+	}
+	if len(mi.Nodes) > 0 {
+		nodes := make([]interface{}, 0, len(mi.Nodes))
+		for _, n := range mi.Nodes {
+			host, port, err := net.SplitHostPort(n)
+			if err != nil {
+				return nil, err
+			}
+			p, err := strconv.Atoi(port)
+			if err != nil {
+				return nil, err
+			}
+			nodes = append(nodes, []interface{}{host, p})
+		}
+		val["nodes"] = nodes
+	}
+
+	node, err := bencode.BEncode(val)
+	if err != nil {
+		return nil, err
+	}
+	infoBytes, err := mi.infoBytes()
+	if err != nil {
+		return nil, err
+	}
+	dict := append(*node.Node.(*bencode.BDict), mi.extra...)
+	sort.SliceStable(dict, func(i, j int) bool {
+		return dict[i].Key < dict[j].Key
+	})
+
+	// the info dict is written as is so the info hash does not change
+	var buff bytes.Buffer
+	buff.WriteByte('d')
+	info := false
+	for _, d := range dict {
+		if !info && d.Key > "info" {
+			buff.WriteString("4:info")
+			buff.Write(infoBytes)
+			info = true
+		}
+		b, err := bencodeutil.Encode(d.Value)
+		if err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(&buff, "%d:%s", len(d.Key), d.Key)
+		buff.Write(b)
+	}
+	if !info {
+		buff.WriteString("4:info")
+		buff.Write(infoBytes)
+	}
+	buff.WriteByte('e')
+	return buff.Bytes(), nil
+}
+
+// infoBytes returns InfoBytes or the encoding of Info when it is nil
+func (mi *MetaInfo) infoBytes() ([]byte, error) {
+	if mi.InfoBytes != nil {
+		return mi.InfoBytes, nil
+	}
+	info := mi.Info
+	val := map[string]interface{}{
+		"name":         info.Name,
+		"piece length": int(info.PieceLength),
+		"pieces":       string(info.Pieces),
+	}
+	if info.Private {
+		val["private"] = 1
+	}
+	setString(val, "source", info.Source)
+	if info.Files == nil {
+		val["length"] = int(info.Length)
+		setString(val, "md5sum", info.MD5Sum)
+		setString(val, "attr", info.Attr)
+	} else {
+		files := make([]interface{}, 0, len(info.Files))
+		for _, f := range info.Files {
+			file := map[string]interface{}{
+				"length": int(f.Length),
+				"path":   toList(f.Path),
+			}
+			setString(file, "md5sum", f.MD5Sum)
+			setString(file, "attr", f.Attr)
+			setStrings(file, "symlink path", f.SymlinkPath)
+			files = append(files, file)
+		}
+		val["files"] = files
+	}
+	node, err := bencode.BEncode(val)
+	if err != nil {
+		return nil, err
+	}
+	return bencodeutil.Encode(node)
+}
+
+// getDictStrings returns the strings at key in the dict, a single string is
+// returned as a list of one
+func getDictStrings(dict *bencode.BDict, key string) []string {
+	node := dict.Get(key)
+	if node == nil {
+		return nil
+	}
+	return getStrings(node)
+}
+
+func getStrings(node *bencode.BNode) []string {
+	if node.Type == bencode.BencodeString {
+		s, _ := node.GetString()
+		return []string{s.ToString()}
+	}
+	list, err := node.GetList()
+	if err != nil {
+		return nil
+	}
+	strs := make([]string, 0, len(list))
+	for _, n := range list {
+		s, err := n.GetString()
+		if err != nil {
+			continue
+		}
+		strs = append(strs, s.ToString())
+	}
+	return strs
+}
+
+func setString(val map[string]interface{}, key string, s string) {
+	if s != "" {
+		val[key] = s
+	}
+}
+
+func setStrings(val map[string]interface{}, key string, strs []string) {
+	if len(strs) > 0 {
+		val[key] = toList(strs)
+	}
+}
+
+func toList(strs []string) []interface{} {
+	list := make([]interface{}, 0, len(strs))
+	for _, s := range strs {
+		list = append(list, s)
+	}
+	return list
+}
+
+// Validate returns the problems of the metainfo, a torrent with problems is
+// rejected. Paths must stay inside the download directory.
+func (mi *MetaInfo) Validate() []error {
+	problems := make([]error, 0)
+	info := &mi.Info
+	if err := validatePathComponent(info.Name); err != nil {
+		problems = append(problems, fmt.Errorf("name: %w", err))
+	}
+	if info.PieceLength <= 0 {
+		problems = append(problems, fmt.Errorf("piece length %d is not positive", info.PieceLength))
+	}
+	if len(info.Pieces)%20 != 0 {
+		problems = append(problems, fmt.Errorf("pieces length %d is not a multiple of 20", len(info.Pieces)))
+	}
+
+	var total int64
+	if info.Files == nil {
+		if info.Length < 0 {
+			problems = append(problems, fmt.Errorf("negative length %d", info.Length))
+		}
+		total = info.Length
+	} else {
+		if info.Length != 0 {
+			problems = append(problems, errors.New("both length and files are set"))
+		}
+		if len(info.Files) == 0 {
+			problems = append(problems, errors.New("empty file list"))
+		}
+		seen := make(map[string]bool)
+		for i, f := range info.Files {
+			if f.Length < 0 {
+				problems = append(problems, fmt.Errorf("file %d: negative length %d", i, f.Length))
+			} else {
+				total += f.Length
+			}
+			if err := validatePath(f.Path); err != nil {
+				problems = append(problems, fmt.Errorf("file %d: path: %w", i, err))
+			} else if p := strings.Join(f.Path, "/"); seen[p] {
+				problems = append(problems, fmt.Errorf("file %d: duplicate path %q", i, p))
+			} else {
+				seen[p] = true
+			}
+			if f.SymlinkPath != nil {
+				if err := validatePath(f.SymlinkPath); err != nil {
+					problems = append(problems, fmt.Errorf("file %d: symlink path: %w", i, err))
+				}
+			}
+		}
+	}
+
+	if info.PieceLength > 0 && len(info.Pieces)%20 == 0 {
+		count := int64(len(info.Pieces) / 20)
+		want := (total + info.PieceLength - 1) / info.PieceLength
+		if count != want {
+			problems = append(problems, fmt.Errorf("%d pieces for a total length of %d, expected %d", count, total, want))
+		}
+	}
+	return problems
+}
+
+func validatePath(p []string) error {
+	if len(p) == 0 {
+		return errors.New("empty path")
+	}
+	for _, c := range p {
+		if err := validatePathComponent(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validatePathComponent rejects components that are empty, move up or
+// contain a separator
+func validatePathComponent(c string) error {
+	switch {
+	case c == "":
+		return errors.New("empty path component")
+	case c == "." || c == "..":
+		return fmt.Errorf("unsafe path component %q", c)
+	case strings.ContainsAny(c, "/\\\x00"):
+		return fmt.Errorf("path component %q contains a separator", c)
+	case filepath.IsAbs(c) || filepath.VolumeName(c) != "":
+		return fmt.Errorf("absolute path component %q", c)
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+package torrentclient
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_MetaInfoRoundTrip(t *testing.T) {
+	pieces := strings.Repeat("p", 20)
+	torrent := "d8:announce9:http://a/13:announce-listll9:http://a/el9:http://b/ee" +
+		"7:comment5:hello10:created by4:test13:creation datei1700000000e8:encoding5:UTF-8" +
+		"4:infod5:filesld4:attr1:x6:lengthi3e6:md5sum32:" + strings.Repeat("0", 32) + "4:pathl1:a1:beed4:attr1:l6:lengthi0e4:pathl1:ce12:symlink pathl1:a1:beee" +
+		"4:name3:dir12:piece lengthi16e6:pieces20:" + pieces + "7:privatei1e6:source3:srce" +
+		"5:nodesll9:127.0.0.1i6881eee8:url-list9:http://w/7:x-extra3:fooe"
+
+	mi, err := ParseMetaInfo(strings.NewReader(torrent))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mi.Comment != "hello" || mi.CreatedBy != "test" || mi.Encoding != "UTF-8" || !mi.CreationDate.Equal(time.Unix(1700000000, 0)) {
+		t.Errorf("unexpected fields %+v", mi)
+	}
+	if len(mi.AnnounceList) != 2 || mi.URLList[0] != "http://w/" || mi.Nodes[0] != "127.0.0.1:6881" {
+		t.Errorf("unexpected lists %v %v %v", mi.AnnounceList, mi.URLList, mi.Nodes)
+	}
+	info := mi.Info
+	if !info.Private || info.Source != "src" || len(info.Files) != 2 || info.Files[0].Attr != "x" ||
+		strings.Join(info.Files[1].SymlinkPath, "/") != "a/b" || len(info.Files[0].MD5Sum) != 32 {
+		t.Errorf("unexpected info %+v", info)
+	}
+
+	b, err := mi.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != torrent {
+		t.Errorf("round trip changed the torrent\n%s\n%s", b, torrent)
+	}
+
+	// without the raw bytes the info dict is encoded from its fields
+	infoBytes := mi.InfoBytes
+	mi.InfoBytes = nil
+	encoded, err := mi.infoBytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(encoded, infoBytes) {
+		t.Errorf("info dict not encoded back\n%s\n%s", encoded, infoBytes)
+	}
+}
+
+func Test_MetaInfoUnsortedInfo(t *testing.T) {
+	// the keys of the info dict are not sorted, re-encoding it would change
+	// the info hash
+	info := "d4:name1:a6:lengthi20e12:piece lengthi16e6:pieces40:" + strings.Repeat("p", 40) + "e"
+	torrent := "d8:announce9:http://a/4:info" + info + "e"
+
+	mi, err := ParseMetaInfo(strings.NewReader(torrent))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := mi.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != torrent {
+		t.Errorf("info dict not kept as is\n%s\n%s", b, torrent)
+	}
+	hash, err := mi.InfoHash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha1.Sum([]byte(info))
+	if !bytes.Equal(hash, sum[:]) {
+		t.Error("info hash not computed from the original info dict")
+	}
+}
+
+func Test_MetaInfoValidate(t *testing.T) {
+	mi := &MetaInfo{Info: InfoDict{
+		Name:        "dir",
+		PieceLength: 16,
+		Pieces:      []byte(strings.Repeat("p", 45)),
+		Files: []FileInfo{
+			{Length: -1, Path: []string{"a"}},
+			{Length: 10, Path: []string{"..", "etc", "passwd"}},
+			{Length: 10, Path: []string{}},
+			{Length: 10, Path: []string{"b/c"}},
+			{Length: 10, Path: []string{"ok"}, SymlinkPath: []string{"..", "x"}},
+		},
+	}}
+	problems := mi.Validate()
+	if len(problems) != 6 {
+		t.Errorf("expected 6 problems, got %v", problems)
+	}
+
+	mi.Info.Pieces = mi.Info.Pieces[:40]
+	mi.Info.Files = []FileInfo{{Length: 20, Path: []string{"a"}}, {Length: 12, Path: []string{"b", "c"}}}
+	if problems := mi.Validate(); len(problems) != 0 {
+		t.Errorf("unexpected problems %v", problems)
+	}
+	mi.Info.Files[1].Length = 13
+	if problems := mi.Validate(); len(problems) != 1 {
+		t.Errorf("inconsistent piece count not reported: %v", problems)
+	}
+
+	mi, err := ParseMetaInfo(strings.NewReader("d4:infod5:filesle4:name1:a12:piece lengthi16e6:pieces0:ee"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if problems := mi.Validate(); len(problems) != 1 {
+		t.Errorf("empty file list not reported: %v", problems)
+	}
+}
@@ -1,166 +1,529 @@
 package torrentclient
 
 import (
+	"bufio"
+	"bytes"
+	"context"
 	"encoding/binary"
+	"errors"
 	"fmt"
-	"log"
+	"io"
 	"net"
+	"net/netip"
+	"os"
+	"time"
+
+	bencode "github.com/tharindu96/bencode-go"
+	"github.com/tharindu96/torrentclient-go/internal/bencodeutil"
 )
 
 // Peer structure
 type Peer struct {
-	torrent *Torrent
-	ID      string
-	IP      string
-	Port    uint16
+	torrent       *Torrent
+	ID            string
+	Addr          netip.AddrPort
+	source        peerSource
+	extended      bool
+	clientVersion string
+	conn          net.Conn
+	bitfield      []byte
+	choked        bool
+	choking       bool
+	interested    bool
+	piece         *pieceDownload
+	requests      int
+	stats         transferStats
+	lastSent      time.Time
+	lastBlock     time.Time
+	snubbed       bool
+
+	// connection manager state, guarded by the torrent lock
+	dialing     bool
+	connected   bool
+	failures    int
+	retryAt     time.Time
+	connectedAt time.Time
+	lastActive  time.Time
+	disconnect  context.CancelFunc
 }
 
-// Connect func
-func (peer *Peer) Connect() {
+// peerSource is where the address of a peer was learned from
+type peerSource uint
 
-	peer.Port = 51413
-	// peer.Port = 45682
+// peerSource Constants
+const (
+	sourceTracker peerSource = iota
+	sourceIncoming
+	sourceDHT
+	sourcePEX
+	sourceLSD
+)
 
-	addr, err := net.ResolveTCPAddr("tcp", peer.getConnectionString())
-	if err != nil {
-		panic(err)
+type messageID uint8
+
+const (
+	msgChoke         messageID = 0
+	msgUnchoke       messageID = 1
+	msgInterested    messageID = 2
+	msgNotInterested messageID = 3
+	msgHave          messageID = 4
+	msgBitfield      messageID = 5
+	msgRequest       messageID = 6
+	msgPiece         messageID = 7
+	msgCancel        messageID = 8
+	msgExtended      messageID = 20
+)
+
+// extendedHandshakeID is the extended message id of the extended handshake
+// (BEP 10)
+const extendedHandshakeID = 0
+
+// message is a peer wire message, a nil message is a keep-alive
+type message struct {
+	ID      messageID
+	Payload []byte
+}
+
+const (
+	protocolName     = "BitTorrent protocol"
+	handshakeLength  = 68
+	maxBlockSize     = 1 << 17
+	maxMessageLength = 1 << 20
+)
+
+// Connect connects to the peer, performs the handshake and exchanges
+// messages until the context is done or the connection fails
+func (peer *Peer) Connect(ctx context.Context) error {
+	client := peer.torrent.client
+	if !client.acquireConnection(peer.torrent) {
+		return peer.wrapError(ErrTooManyConnections)
 	}
+	defer client.releaseConnection(peer.torrent)
 
-	conn, err := net.DialTCP("tcp", nil, addr)
+	dialer := net.Dialer{Timeout: client.config.DialTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", peer.getConnectionString())
 	if err != nil {
-		panic(err)
+		return peer.wrapError(err)
 	}
+	return peer.serve(ctx, conn, func() error {
+		err := writeHandshake(peer.conn, peer.torrent.InfoHash, client.GetID())
+		if err != nil {
+			return err
+		}
+		infoHash, id, reserved, err := readHandshake(peer.conn)
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(infoHash, peer.torrent.InfoHash) {
+			return ErrInfoHashMismatch
+		}
+		peer.torrent.mu.Lock()
+		peer.ID = id
+		peer.torrent.mu.Unlock()
+		peer.extended = supportsExtensions(reserved)
+		return nil
+	})
+}
 
-	log.Println("Connected OK!")
+// serve completes the handshake on conn and exchanges messages until the
+// context is done or the connection fails
+func (peer *Peer) serve(ctx context.Context, conn net.Conn, handshake func() error) error {
+	client := peer.torrent.client
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stop := context.AfterFunc(ctx, func() {
+		conn.Close()
+	})
+	defer stop()
+	peer.resetConnection()
+	peer.conn = &statsConn{Conn: conn, peer: peer}
+
+	conn.SetDeadline(time.Now().Add(client.config.HandshakeTimeout))
+	err := handshake()
+	if err != nil {
+		conn.Close()
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		}
+		return peer.wrapError(err)
+	}
+	conn.SetDeadline(time.Time{})
+	err = peer.torrent.registerPeer(peer, cancel)
+	if err != nil {
+		conn.Close()
+		return peer.wrapError(err)
+	}
+	defer peer.torrent.unregisterPeer(peer)
+	peer.emitConnected()
 
-	handshake := string(19) + "BitTorrent protocol"
-	buff := []byte(handshake)
-	conn.Write(buff)
-	log.Println("handshake OK!")
+	err = peer.run(ctx)
+	conn.Close()
+	peer.releasePiece()
+	peer.releaseUploadSlot()
+	if ctx.Err() != nil {
+		err = ctx.Err()
+	}
+	err = peer.wrapError(err)
+	peer.emitDisconnected(err)
+	return err
+}
 
-	buff = []byte{0, 0, 0, 0, 0, 0, 0, 0}
-	// buff = append(buff, []byte{0, 0, 0, 0, 0, 0, 0, 0}...)
-	conn.Write(buff)
-	log.Println("reserved OK!")
+// resetConnection clears the state of a previous connection to the peer, the
+// same peer is reused when it is connected again
+func (peer *Peer) resetConnection() {
+	peer.bitfield = nil
+	peer.choked = true
+	peer.choking = true
+	peer.interested = false
+	peer.piece = nil
+	peer.requests = 0
+	peer.lastSent = time.Time{}
+	peer.lastBlock = time.Time{}
+	peer.snubbed = false
+	peer.torrent.mu.Lock()
+	peer.clientVersion = ""
+	peer.torrent.mu.Unlock()
+}
 
-	buff = peer.torrent.InfoHash
-	// buff = append(buff, peer.torrent.InfoHash...)
-	conn.Write(buff)
-	log.Println("infoHash OK")
+func writeHandshake(w io.Writer, infoHash []byte, id string) error {
+	buff := make([]byte, 0, handshakeLength)
+	buff = append(buff, byte(len(protocolName)))
+	buff = append(buff, protocolName...)
+	// the extension protocol is supported (BEP 10)
+	buff = append(buff, 0, 0, 0, 0, 0, 0x10, 0, 0)
+	buff = append(buff, infoHash...)
+	buff = append(buff, padPeerID(id)...)
+	_, err := w.Write(buff)
+	return err
+}
 
-	buff = []byte(fmt.Sprintf("%20s", peer.torrent.GetClient().GetID()))
-	// buff = append(buff, []byte(peer.torrent.GetClient().GetID())...)
-	conn.Write(buff)
+// padPeerID pads the id of the client to the 20 bytes of a peer id
+func padPeerID(id string) string {
+	return fmt.Sprintf("%20s", id)
+}
 
-	rbuff := make([]byte, 68)
-	_, err = conn.Read(rbuff)
+// readHandshake reads a handshake and returns the info hash, the peer id and
+// the reserved bytes
+func readHandshake(r io.Reader) ([]byte, string, []byte, error) {
+	rbuff := make([]byte, handshakeLength)
+	_, err := io.ReadFull(r, rbuff)
 	if err != nil {
-		panic(err)
+		return nil, "", nil, err
 	}
+	if int(rbuff[0]) != len(protocolName) || string(rbuff[1:20]) != protocolName {
+		return nil, "", nil, ErrInvalidHandshake
+	}
+	return rbuff[28:48], string(rbuff[48:68]), rbuff[20:28], nil
+}
 
-	hash := rbuff[28:48]
-	id := rbuff[48:68]
-
-	log.Println(string(hash) == string(peer.torrent.InfoHash))
-	log.Println(string(id))
+func supportsExtensions(reserved []byte) bool {
+	return reserved[5]&0x10 != 0
+}
 
-	readMessage(conn)
-	readMessage(conn)
+func (peer *Peer) run(ctx context.Context) error {
+	if peer.extended {
+		err := peer.writeExtendedHandshake()
+		if err != nil {
+			return err
+		}
+	}
+	bitfield := peer.torrent.getBitfield()
+	if bitfield != nil {
+		err := writeMessage(peer.conn, &message{ID: msgBitfield, Payload: bitfield})
+		if err != nil {
+			return err
+		}
+	}
+	err := interestedMessage(peer.conn)
+	if err != nil {
+		return err
+	}
 
-	interesetdMessage(conn)
-	requestMessage(conn, 1, 0, 1024)
-	requestMessage(conn, 1, 0, 1024)
-	requestMessage(conn, 1, 0, 1024)
-	requestMessage(conn, 1, 0, 1024)
-	requestMessage(conn, 1, 0, 1024)
-	requestMessage(conn, 1, 0, 1024)
+	msgs := make(chan *message)
+	errc := make(chan error, 1)
+	done := make(chan struct{})
+	defer close(done)
+	go peer.readMessages(msgs, errc, done)
+	ticker := time.NewTicker(peer.tickInterval())
+	defer ticker.Stop()
+	for {
+		select {
+		case msg := <-msgs:
+			err = peer.handleMessage(ctx, msg)
+		case err = <-errc:
+		case now := <-ticker.C:
+			err = peer.tick(now)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
 
-	readMessage(conn)
-	readMessage(conn)
+// readMessages reads messages from the peer until the connection fails or
+// done is closed, a peer sending nothing for the peer timeout is disconnected
+func (peer *Peer) readMessages(msgs chan<- *message, errc chan<- error, done <-chan struct{}) {
+	timeout := peer.torrent.client.config.PeerTimeout
+	for {
+		peer.conn.SetReadDeadline(time.Now().Add(timeout))
+		msg, err := readMessage(peer.conn)
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			err = ErrPeerTimeout
+		}
+		if err != nil {
+			errc <- err
+			return
+		}
+		select {
+		case msgs <- msg:
+		case <-done:
+			return
+		}
+	}
+}
 
-	conn.Close()
+func (peer *Peer) handleMessage(ctx context.Context, msg *message) error {
+	if msg == nil {
+		return nil
+	}
+	switch msg.ID {
+	case msgChoke:
+		peer.choked = true
+		peer.releasePiece()
+	case msgUnchoke:
+		peer.choked = false
+		return peer.requestBlocks()
+	case msgInterested:
+		peer.interested = true
+		if !peer.snubbed && peer.torrent.acquireUploadSlot() {
+			peer.choking = false
+			return unchokeMessage(peer.conn)
+		}
+	case msgNotInterested:
+		peer.interested = false
+		if !peer.choking {
+			peer.releaseUploadSlot()
+			return chokeMessage(peer.conn)
+		}
+	case msgRequest:
+		return peer.handleRequest(ctx, msg.Payload)
+	case msgHave:
+		if len(msg.Payload) != 4 {
+			return ErrInvalidMessage
+		}
+		peer.setPiece(int(binary.BigEndian.Uint32(msg.Payload)))
+		return peer.requestBlocks()
+	case msgBitfield:
+		peer.bitfield = append([]byte(nil), msg.Payload...)
+		return peer.requestBlocks()
+	case msgPiece:
+		return peer.handlePiece(ctx, msg.Payload)
+	case msgExtended:
+		return peer.handleExtended(msg.Payload)
+	}
+	return nil
 }
 
-func requestMessage(conn *net.TCPConn, index uint32, offset uint32, length uint32) {
-	msg := make([]byte, 14)
-	msg[0] = 13
-	msg[1] = 6
-	binary.BigEndian.PutUint32(msg[2:6], index)
-	binary.BigEndian.PutUint32(msg[6:10], offset)
-	binary.BigEndian.PutUint32(msg[10:14], length)
-	log.Printf("% x", msg)
-	i, err := conn.Write(msg)
+func (peer *Peer) writeExtendedHandshake() error {
+	node, err := bencode.BEncode(map[string]interface{}{
+		"m": map[string]interface{}{},
+		"p": int(peer.torrent.client.GetPort()),
+		"v": clientName,
+	})
 	if err != nil {
-		panic(err)
+		return err
 	}
-	log.Println("Wrote:", i)
+	b, err := bencodeutil.Encode(node)
+	if err != nil {
+		return err
+	}
+	payload := append([]byte{extendedHandshakeID}, b...)
+	return writeMessage(peer.conn, &message{ID: msgExtended, Payload: payload})
 }
 
-func keepaliveMessage(conn *net.TCPConn) {
-	msg := make([]byte, 1)
-	msg[0] = 0
-	i, err := conn.Write(msg)
+// handleExtended reads the extended handshake, other extended messages are
+// ignored as no extension is advertised
+func (peer *Peer) handleExtended(payload []byte) error {
+	if len(payload) < 1 {
+		return ErrInvalidMessage
+	}
+	if payload[0] != extendedHandshakeID {
+		return nil
+	}
+	node, err := bencode.BRead(bufio.NewReader(bytes.NewReader(payload[1:])))
 	if err != nil {
-		panic(err)
+		return fmt.Errorf("%w: %w", ErrInvalidMessage, err)
 	}
-	log.Println("Wrote:", i)
+	dict, err := node.GetDict()
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidMessage, err)
+	}
+	v := getDictString(&dict, "v")
+	peer.torrent.mu.Lock()
+	peer.clientVersion = v
+	peer.torrent.mu.Unlock()
+	return nil
 }
 
-func interesetdMessage(conn *net.TCPConn) {
-	msg := make([]byte, 2)
-	msg[0] = 1
-	msg[1] = 2
-	i, err := conn.Write(msg)
+func (peer *Peer) handleRequest(ctx context.Context, payload []byte) error {
+	if len(payload) != 12 {
+		return ErrInvalidMessage
+	}
+	if peer.choking {
+		return nil
+	}
+	index := int(binary.BigEndian.Uint32(payload[0:4]))
+	begin := binary.BigEndian.Uint32(payload[4:8])
+	length := binary.BigEndian.Uint32(payload[8:12])
+	if length > maxBlockSize || !peer.torrent.hasPiece(index) ||
+		uint(begin)+uint(length) > peer.torrent.pieceLength(index) {
+		return ErrInvalidMessage
+	}
+	block := make([]byte, 8+length)
+	copy(block[0:8], payload[0:8])
+	_, err := peer.torrent.storage.ReadAt(block[8:], peer.torrent.pieceOffset(index)+int64(begin))
+	if err != nil {
+		return err
+	}
+	_, upload := peer.torrent.client.getLimiters(peer.isLocal())
+	err = waitAll(ctx, int(length), peer.torrent.uploadLimiter, upload)
 	if err != nil {
-		panic(err)
+		return err
 	}
-	log.Println("Wrote:", i)
+	err = writeMessage(peer.conn, &message{ID: msgPiece, Payload: block})
+	if err != nil {
+		return err
+	}
+	peer.statsGroup().addUploadedPayload(int(length))
+	peer.touch()
+	return nil
 }
 
-func unchokeMessage(conn *net.TCPConn) {
-	msg := make([]byte, 2)
-	msg[0] = 1
-	msg[1] = 1
-	i, err := conn.Write(msg)
-	if err != nil {
-		panic(err)
+func (peer *Peer) releaseUploadSlot() {
+	if !peer.choking {
+		peer.torrent.releaseUploadSlot()
+		peer.choking = true
+	}
+}
+
+func (peer *Peer) hasPiece(index int) bool {
+	i := index / 8
+	if i < 0 || i >= len(peer.bitfield) {
+		return false
+	}
+	return peer.bitfield[i]&(0x80>>uint(index%8)) != 0
+}
+
+func (peer *Peer) setPiece(index int) {
+	i := index / 8
+	if i < 0 || index >= len(peer.torrent.Pieces) {
+		return
+	}
+	if i >= len(peer.bitfield) {
+		bitfield := make([]byte, (len(peer.torrent.Pieces)+7)/8)
+		copy(bitfield, peer.bitfield)
+		peer.bitfield = bitfield
 	}
-	log.Println("Wrote:", i)
+	peer.bitfield[i] |= 0x80 >> uint(index%8)
 }
 
-func readMessage(conn *net.TCPConn) {
+// isLocal returns true if the peer is on the local network
+func (peer *Peer) isLocal() bool {
+	ip := peer.Addr.Addr()
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast()
+}
+
+func (peer *Peer) wrapError(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &PeerError{
+		Addr: peer.getConnectionString(),
+		Err:  err,
+	}
+}
+
+func (peer *Peer) emitConnected() {
+	peer.torrent.client.events.emit(PeerConnectedEvent{
+		torrentEvent: torrentEvent{peer.torrent},
+		Peer:         peer,
+	})
+}
+
+func (peer *Peer) emitDisconnected(err error) {
+	peer.torrent.client.events.emit(PeerDisconnectedEvent{
+		torrentEvent: torrentEvent{peer.torrent},
+		Peer:         peer,
+		Err:          err,
+	})
+}
+
+func requestMessage(conn net.Conn, index uint32, offset uint32, length uint32) error {
+	payload := make([]byte, 12)
+	binary.BigEndian.PutUint32(payload[0:4], index)
+	binary.BigEndian.PutUint32(payload[4:8], offset)
+	binary.BigEndian.PutUint32(payload[8:12], length)
+	return writeMessage(conn, &message{ID: msgRequest, Payload: payload})
+}
+
+func keepaliveMessage(conn net.Conn) error {
+	return writeMessage(conn, nil)
+}
+
+func interestedMessage(conn net.Conn) error {
+	return writeMessage(conn, &message{ID: msgInterested})
+}
+
+func unchokeMessage(conn net.Conn) error {
+	return writeMessage(conn, &message{ID: msgUnchoke})
+}
+
+func chokeMessage(conn net.Conn) error {
+	return writeMessage(conn, &message{ID: msgChoke})
+}
+
+func writeMessage(w io.Writer, msg *message) error {
+	if msg == nil {
+		_, err := w.Write([]byte{0, 0, 0, 0})
+		return err
+	}
+	buff := make([]byte, 5+len(msg.Payload))
+	binary.BigEndian.PutUint32(buff[0:4], uint32(1+len(msg.Payload)))
+	buff[4] = byte(msg.ID)
+	copy(buff[5:], msg.Payload)
+	_, err := w.Write(buff)
+	return err
+}
+
+func readMessage(r io.Reader) (*message, error) {
 
 	lenBuffer := make([]byte, 4)
-	var err error
 
-	_, err = conn.Read(lenBuffer)
+	_, err := io.ReadFull(r, lenBuffer)
 	if err != nil {
-		fmt.Printf("%s\n", err.Error())
-		return
+		return nil, err
 	}
 
 	lenPrefix := binary.BigEndian.Uint32(lenBuffer)
+	if lenPrefix == 0 {
+		return nil, nil
+	}
+	if lenPrefix > maxMessageLength {
+		return nil, ErrInvalidMessage
+	}
 
-	if lenPrefix > 0 {
-		bodyBuffer := make([]byte, lenPrefix)
-		_, err = conn.Read(bodyBuffer)
-		if err != nil {
-			panic(err)
-		}
-		id := int(bodyBuffer[0])
-		log.Println("Read ID:", id)
-		switch id {
-		case 5:
-			log.Printf("%b", bodyBuffer[1:])
-
-			break
-		default:
-			log.Println(bodyBuffer[1:])
-		}
+	bodyBuffer := make([]byte, lenPrefix)
+	_, err = io.ReadFull(r, bodyBuffer)
+	if err != nil {
+		return nil, err
 	}
 
+	return &message{
+		ID:      messageID(bodyBuffer[0]),
+		Payload: bodyBuffer[1:],
+	}, nil
 }
 
 func (peer *Peer) getConnectionString() string {
-	return fmt.Sprintf("%s:%d", peer.IP, peer.Port)
+	return peer.Addr.String()
 }
@@ -0,0 +1,104 @@
+package torrentclient
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+func Test_PeerDownload(t *testing.T) {
+	seeder, data := newTestTorrent(t, 16, 20, 30)
+	leecher, _ := newTestTorrent(t, 16, 20, 30)
+	for i := range seeder.Pieces {
+		_, err := seeder.pieceDownloaded(i, data[i*16:min((i+1)*16, len(data))])
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	seeder.client.addTorrentIfAbsent(seeder)
+
+	listener, err := net.Listen("tcp", "[::1]:0")
+	if err != nil {
+		listener, err = net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	defer listener.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	seeder.Start(ctx)
+	defer seeder.Stop()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go seeder.client.handleIncoming(ctx, conn)
+		}
+	}()
+
+	sub := leecher.client.Subscribe(leecher, 64)
+	defer sub.Unsubscribe()
+
+	peer := &Peer{
+		torrent: leecher,
+		Addr:    netip.MustParseAddrPort(listener.Addr().String()),
+	}
+	go peer.Connect(ctx)
+
+	for {
+		select {
+		case e := <-sub.C:
+			if _, ok := e.(DownloadCompleteEvent); ok {
+				if peer.Stats().DownloadedPayload != int64(len(data)) {
+					t.Errorf("unexpected payload count %d", peer.Stats().DownloadedPayload)
+				}
+				if peer.Client() != clientName {
+					t.Errorf("unexpected client %q", peer.Client())
+				}
+				return
+			}
+		case <-ctx.Done():
+			t.Fatal("download did not complete")
+		}
+	}
+}
+
+func Test_HandlePieceRequestedBlocks(t *testing.T) {
+	torrent, data := newTestTorrent(t, 16, 20)
+	peer := &Peer{torrent: torrent, choked: true}
+	peer.piece = &pieceDownload{
+		index:     0,
+		data:      make([]byte, 16),
+		requested: 16,
+		pending:   map[int]int{0: 8, 8: 8},
+	}
+	peer.requests = 2
+	block := func(begin, length int) []byte {
+		payload := make([]byte, 8, 8+length)
+		binary.BigEndian.PutUint32(payload[4:8], uint32(begin))
+		return append(payload, data[begin:begin+length]...)
+	}
+
+	ctx := context.Background()
+	for _, b := range [][]byte{block(0, 8), block(0, 8), block(4, 8), block(8, 4)} {
+		if err := peer.handlePiece(ctx, b); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if peer.piece.received != 8 || peer.requests != 1 {
+		t.Fatalf("unrequested blocks counted: received %d, %d requests", peer.piece.received, peer.requests)
+	}
+	if err := peer.handlePiece(ctx, block(8, 8)); err != nil {
+		t.Fatal(err)
+	}
+	if !torrent.Pieces[0].Complete || peer.requests != 0 {
+		t.Error("piece not completed by the requested blocks")
+	}
+}
@@ -0,0 +1,100 @@
+package torrentclient
+
+import (
+	"crypto/rand"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// The client identifies itself as -TG0100- in peer ids (BEP 20)
+const (
+	clientCode    = "TG"
+	clientVersion = "0100"
+	clientName    = "torrentclient-go 0.1.0"
+)
+
+const peerIDChars = "0123456789abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
+
+// azureusClients maps the client codes of Azureus style peer ids to names
+var azureusClients = map[string]string{
+	"AZ": "Vuze",
+	"BI": "BiglyBT",
+	"BT": "BitTorrent",
+	"DE": "Deluge",
+	"FD": "Free Download Manager",
+	"KT": "KTorrent",
+	"LT": "libtorrent",
+	"lt": "rTorrent",
+	"qB": "qBittorrent",
+	"TG": "torrentclient-go",
+	"TR": "Transmission",
+	"UT": "µTorrent",
+	"UW": "µTorrent Web",
+	"WW": "WebTorrent",
+}
+
+var (
+	azureusPeerID  = regexp.MustCompile(`^-([A-Za-z~]{2})([0-9A-Za-z]{4})-`)
+	mainlinePeerID = regexp.MustCompile(`^M(\d+)-(\d+)-(\d+)-`)
+)
+
+// GeneratePeerID returns a new peer id of the client, the Azureus style
+// prefix followed by random characters. It panics if the system random source
+// fails, a predictable id would be shared with other clients.
+func GeneratePeerID() string {
+	suffix := make([]byte, 12)
+	if _, err := rand.Read(suffix); err != nil {
+		panic(fmt.Sprintf("generating peer id: %v", err))
+	}
+	for i, b := range suffix {
+		suffix[i] = peerIDChars[int(b)%len(peerIDChars)]
+	}
+	return "-" + clientCode + clientVersion + "-" + string(suffix)
+}
+
+// ParseClient returns the name and version of the client that generated the
+// peer id, an empty string if it is not recognized
+func ParseClient(peerID string) string {
+	if m := azureusPeerID.FindStringSubmatch(peerID); m != nil {
+		name, ok := azureusClients[m[1]]
+		if !ok {
+			name = m[1]
+		}
+		return name + " " + azureusVersion(m[2])
+	}
+	if m := mainlinePeerID.FindStringSubmatch(peerID); m != nil {
+		return fmt.Sprintf("BitTorrent %s.%s.%s", m[1], m[2], m[3])
+	}
+	return ""
+}
+
+// azureusVersion turns the four version characters into a dotted version,
+// the fourth is only kept when it is not zero
+func azureusVersion(v string) string {
+	parts := make([]string, 0, 4)
+	for i, c := range v {
+		n, err := strconv.ParseInt(string(c), 36, 64)
+		if err != nil {
+			n = 0
+		}
+		if i == 3 && n == 0 {
+			break
+		}
+		parts = append(parts, strconv.FormatInt(n, 10))
+	}
+	return strings.Join(parts, ".")
+}
+
+// Client returns the name and version of the client of the peer, from its
+// extended handshake or else from its peer id
+func (peer *Peer) Client() string {
+	peer.torrent.mu.Lock()
+	v, id := peer.clientVersion, peer.ID
+	peer.torrent.mu.Unlock()
+	if v != "" {
+		return v
+	}
+	return ParseClient(id)
+}
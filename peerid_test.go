@@ -0,0 +1,28 @@
+package torrentclient
+
+import (
+	"strings"
+	"testing"
+)
+
+func Test_PeerID(t *testing.T) {
+	id := GeneratePeerID()
+	if len(id) != 20 || !strings.HasPrefix(id, "-TG0100-") || id == GeneratePeerID() {
+		t.Errorf("unexpected peer id %q", id)
+	}
+	if c := ParseClient(id); c != "torrentclient-go 0.1.0" {
+		t.Errorf("unexpected client %q", c)
+	}
+
+	for id, want := range map[string]string{
+		"-qB4250-abcdefghijkl":    "qBittorrent 4.2.5",
+		"-TR300Z-abcdefghijkl":    "Transmission 3.0.0.35",
+		"-XX1000-abcdefghijkl":    "XX 1.0.0",
+		"M7-10-3--abcdefghijk":    "BitTorrent 7.10.3",
+		"\x00\x01garbage-peer-id": "",
+	} {
+		if c := ParseClient(id); c != want {
+			t.Errorf("%q: expected %q, got %q", id, want, c)
+		}
+	}
+}
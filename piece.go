@@ -1,7 +1,129 @@
 package torrentclient
 
+import (
+	"context"
+	"crypto/sha1"
+	"fmt"
+)
+
 // Piece struct
 type Piece struct {
-	Hash     string
-	Complete bool
+	Hash        string
+	Complete    bool
+	downloading bool
+	priority    FilePriority
+}
+
+// pieceLength returns the length of the piece at index, the last piece is
+// usually shorter than the others
+func (torrent *Torrent) pieceLength(index int) uint {
+	if index < len(torrent.Pieces)-1 {
+		return torrent.PieceLength
+	}
+	return uint(torrent.totalLength() - torrent.pieceOffset(index))
+}
+
+func (torrent *Torrent) pieceOffset(index int) int64 {
+	return int64(index) * int64(torrent.PieceLength)
+}
+
+func (torrent *Torrent) checkPiece(index int, data []byte) bool {
+	sum := sha1.Sum(data)
+	return string(sum[:]) == torrent.Pieces[index].Hash
+}
+
+// pieceDownloaded checks the data of the piece at index against its hash,
+// writes it to the storage and marks it complete, false is returned if the
+// hash does not match
+func (torrent *Torrent) pieceDownloaded(index int, data []byte) (bool, error) {
+	if !torrent.checkPiece(index, data) {
+		torrent.client.events.emit(PieceFailedEvent{
+			torrentEvent: torrentEvent{torrent},
+			Index:        index,
+		})
+		return false, nil
+	}
+
+	_, err := torrent.storage.WriteAt(data, torrent.pieceOffset(index))
+	if err != nil {
+		return false, err
+	}
+
+	torrent.mu.Lock()
+	torrent.Pieces[index].Complete = true
+	done := torrent.isWantedComplete()
+	if done {
+		torrent.markComplete()
+	}
+	torrent.pieceCond.Broadcast()
+	torrent.mu.Unlock()
+
+	torrent.client.events.emit(PieceVerifiedEvent{
+		torrentEvent: torrentEvent{torrent},
+		Index:        index,
+	})
+	if done {
+		torrent.client.events.emit(DownloadCompleteEvent{torrentEvent{torrent}})
+		torrent.setState(StateSeeding)
+		// the data is complete even if the links can not be created
+		err = torrent.storage.createLinks()
+		if err != nil {
+			return true, fmt.Errorf("creating links: %w", err)
+		}
+	}
+	return true, nil
+}
+
+func (torrent *Torrent) hasPiece(index int) bool {
+	torrent.mu.Lock()
+	defer torrent.mu.Unlock()
+	return index >= 0 && index < len(torrent.Pieces) && torrent.Pieces[index].Complete
+}
+
+// getBitfield returns the bitfield of the complete pieces, nil if there are
+// none
+func (torrent *Torrent) getBitfield() []byte {
+	torrent.mu.Lock()
+	defer torrent.mu.Unlock()
+	var bitfield []byte
+	for i, p := range torrent.Pieces {
+		if !p.Complete {
+			continue
+		}
+		if bitfield == nil {
+			bitfield = make([]byte, (len(torrent.Pieces)+7)/8)
+		}
+		bitfield[i/8] |= 0x80 >> uint(i%8)
+	}
+	return bitfield
+}
+
+// Recheck hashes the data already in the data directory and marks the pieces
+// that match as complete, the links and empty files of a complete torrent are
+// created. A torrent without metadata has nothing to check.
+func (torrent *Torrent) Recheck(ctx context.Context) error {
+	buff := make([]byte, torrent.PieceLength)
+	for i := range torrent.Pieces {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		data := buff[:torrent.pieceLength(i)]
+		_, err := torrent.storage.ReadAt(data, torrent.pieceOffset(i))
+		complete := err == nil && torrent.checkPiece(i, data)
+		torrent.mu.Lock()
+		torrent.Pieces[i].Complete = complete
+		torrent.pieceCond.Broadcast()
+		torrent.mu.Unlock()
+	}
+	torrent.mu.Lock()
+	done := torrent.hasMetadata() && torrent.isWantedComplete()
+	torrent.mu.Unlock()
+	if done {
+		torrent.setState(StateSeeding)
+		err := torrent.storage.createLinks()
+		if err != nil {
+			return fmt.Errorf("creating links: %w", err)
+		}
+	}
+	return nil
 }
@@ -0,0 +1,86 @@
+package torrentclient
+
+import (
+	"errors"
+)
+
+// FilePriority is the download priority of a file
+type FilePriority int
+
+// FilePriority Constants, files with PrioritySkip are not downloaded
+const (
+	PrioritySkip   FilePriority = 0
+	PriorityLow    FilePriority = 1
+	PriorityNormal FilePriority = 2
+	PriorityHigh   FilePriority = 3
+)
+
+// SetFilePriority changes the priority of the file at index, it can be
+// changed while the torrent is running
+func (torrent *Torrent) SetFilePriority(index int, priority FilePriority) error {
+	if priority < PrioritySkip || priority > PriorityHigh {
+		return errors.New("invalid priority")
+	}
+	torrent.mu.Lock()
+	files := torrent.Files
+	torrent.mu.Unlock()
+	if index < 0 || index >= len(files) {
+		return errors.New("file index out of range")
+	}
+	if files[index].IsPad() {
+		return errors.New("pad files are not downloaded")
+	}
+	return torrent.storage.setFilePriority(index, priority)
+}
+
+// GetFilePriority returns the priority of the file at index
+func (torrent *Torrent) GetFilePriority(index int) (FilePriority, error) {
+	torrent.mu.Lock()
+	defer torrent.mu.Unlock()
+	if index < 0 || index >= len(torrent.Files) {
+		return PrioritySkip, errors.New("file index out of range")
+	}
+	return torrent.Files[index].priority, nil
+}
+
+// updatePiecePriorities gives every piece the highest priority of the files
+// it covers, so a piece shared with a wanted file is still downloaded, and
+// raises the pieces ahead of the open readers. The torrent must be locked.
+func (torrent *Torrent) updatePiecePriorities() {
+	for i, p := range torrent.Pieces {
+		p.priority = PrioritySkip
+		first, last := torrent.pieceFileRange(i)
+		for f := first; f <= last; f++ {
+			if torrent.Files[f].priority > p.priority {
+				p.priority = torrent.Files[f].priority
+			}
+		}
+	}
+	for r := range torrent.readers {
+		first, last := r.window()
+		for i := first; i <= last && i < len(torrent.Pieces); i++ {
+			torrent.Pieces[i].priority = priorityReadahead
+		}
+	}
+}
+
+// pieceFileRange returns the indexes of the first and the last file the piece
+// at index covers
+func (torrent *Torrent) pieceFileRange(index int) (int, int) {
+	ranges := torrent.PieceFileRanges(index)
+	if len(ranges) == 0 {
+		return -1, -1
+	}
+	return ranges[0].File, ranges[len(ranges)-1].File
+}
+
+// isWantedComplete returns true if every piece that is not skipped is
+// complete. The torrent must be locked.
+func (torrent *Torrent) isWantedComplete() bool {
+	for _, p := range torrent.Pieces {
+		if !p.Complete && p.priority > PrioritySkip {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,61 @@
+package torrentclient
+
+// Private torrents (BEP 27) only take the peers their trackers hand out and
+// the peers that connect to us. Peers are only added to the torrent they were
+// found for, so a private torrent never uses the peers of another torrent.
+
+// DHTEnabled returns whether peers of the torrent may be looked up in the DHT
+func (torrent *Torrent) DHTEnabled() bool {
+	return torrent.client.config.DHT && !torrent.Private
+}
+
+// PEXEnabled returns whether peers of the torrent may be exchanged with other
+// peers
+func (torrent *Torrent) PEXEnabled() bool {
+	return torrent.client.config.PEX && !torrent.Private
+}
+
+// LSDEnabled returns whether peers of the torrent may be discovered on the
+// local network
+func (torrent *Torrent) LSDEnabled() bool {
+	return torrent.client.config.LSD && !torrent.Private
+}
+
+// allowsSource returns whether peers learned from the source may be used
+func (torrent *Torrent) allowsSource(source peerSource) bool {
+	switch source {
+	case sourceTracker, sourceIncoming:
+		return true
+	case sourceDHT:
+		return torrent.DHTEnabled()
+	case sourcePEX:
+		return torrent.PEXEnabled()
+	case sourceLSD:
+		return torrent.LSDEnabled()
+	default:
+		return false
+	}
+}
+
+// addPeers adds the peers that are not known yet, peers from a source the
+// torrent does not allow are dropped. The number of peers added is returned.
+func (torrent *Torrent) addPeers(peers []*Peer, source peerSource) int {
+	if !torrent.allowsSource(source) {
+		return 0
+	}
+	torrent.mu.Lock()
+	defer torrent.mu.Unlock()
+	added := 0
+	for _, p := range peers {
+		if p.torrent != torrent {
+			continue
+		}
+		if _, ok := torrent.Peers[p.Addr.String()]; ok {
+			continue
+		}
+		p.source = source
+		torrent.Peers[p.Addr.String()] = p
+		added++
+	}
+	return added
+}
@@ -0,0 +1,93 @@
+package torrentclient
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	bencode "github.com/tharindu96/bencode-go"
+)
+
+func Test_PrivateTorrent(t *testing.T) {
+	torrent, _ := newTestTorrent(t, 16, 20)
+
+	info := "d6:lengthi20e4:name1:a12:piece lengthi16e6:pieces40:" + strings.Repeat("x", 40) + "7:privatei1ee"
+	node, err := bencode.BRead(bufio.NewReader(strings.NewReader(info)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dict, err := node.GetDict()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := parseTorrentInfo(&dict, torrent); err != nil {
+		t.Fatal(err)
+	}
+	if !torrent.Private || torrent.DHTEnabled() || torrent.PEXEnabled() || torrent.LSDEnabled() {
+		t.Fatal("private flag not honored")
+	}
+
+	peer := func(addr string) []*Peer {
+		return []*Peer{{torrent: torrent, Addr: netip.MustParseAddrPort(addr)}}
+	}
+	if torrent.addPeers(peer("10.0.0.1:1"), sourcePEX) != 0 || torrent.addPeers(peer("10.0.0.2:1"), sourceDHT) != 0 {
+		t.Error("private torrent accepted peers outside of its trackers")
+	}
+	if torrent.addPeers(peer("10.0.0.3:1"), sourceTracker) != 1 {
+		t.Error("private torrent refused a tracker peer")
+	}
+}
+
+func Test_PrivateTorrentTrackers(t *testing.T) {
+	private, _ := newTestTorrent(t, 16, 20)
+	private.Private = true
+	other, _ := newTestTorrent(t, 16, 20)
+	other.InfoHash[0] = 1
+
+	tracker := func(hits *atomic.Int32, peer string) *httptest.Server {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			hits.Add(1)
+			w.Write([]byte("d8:intervali900e5:peers6:" + peer + "e"))
+		}))
+		t.Cleanup(server.Close)
+		return server
+	}
+	var privateHits, otherHits atomic.Int32
+	privateServer := tracker(&privateHits, "\x0a\x00\x00\x01\x00\x01")
+	otherServer := tracker(&otherHits, "\x0a\x00\x00\x02\x00\x01")
+	private.Tiers = [][]*Tracker{{NewTracker(privateServer.URL, 0, private)}}
+	other.Tiers = [][]*Tracker{{NewTracker(otherServer.URL, 0, other)}}
+
+	if err := private.RequestTrackers(context.Background(), false); err != nil {
+		t.Fatal(err)
+	}
+	if privateHits.Load() != 1 || otherHits.Load() != 0 {
+		t.Errorf("unexpected announces %d to the private tracker, %d to the other", privateHits.Load(), otherHits.Load())
+	}
+	if err := other.RequestTrackers(context.Background(), false); err != nil {
+		t.Fatal(err)
+	}
+	if privateHits.Load() != 1 {
+		t.Error("the private tracker got an announce of another torrent")
+	}
+
+	other.mu.Lock()
+	peers := make([]*Peer, 0, len(other.Peers))
+	for _, p := range other.Peers {
+		peers = append(peers, p)
+	}
+	other.mu.Unlock()
+	if len(peers) != 1 || private.addPeers(peers, sourceTracker) != 0 {
+		t.Error("private torrent accepted the peers of another torrent")
+	}
+	private.mu.Lock()
+	defer private.mu.Unlock()
+	if _, ok := private.Peers["10.0.0.1:1"]; !ok || len(private.Peers) != 1 {
+		t.Errorf("unexpected peers of the private torrent %v", private.Peers)
+	}
+}
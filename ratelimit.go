@@ -0,0 +1,98 @@
+package torrentclient
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// RateLimiter is a token bucket limiting a transfer rate in bytes per second,
+// a limit of 0 means unlimited
+type RateLimiter struct {
+	mu     sync.Mutex
+	limit  int
+	tokens float64
+	last   time.Time
+}
+
+// NewRateLimiter returns a new RateLimiter with the limit in bytes per second
+func NewRateLimiter(limit int) *RateLimiter {
+	return &RateLimiter{
+		limit:  limit,
+		tokens: float64(limit),
+		last:   time.Now(),
+	}
+}
+
+// SetLimit changes the limit, it applies to waits started after the call
+func (l *RateLimiter) SetLimit(limit int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.refill(time.Now())
+	l.limit = limit
+	if l.tokens > float64(limit) {
+		l.tokens = float64(limit)
+	}
+}
+
+// GetLimit returns the limit in bytes per second
+func (l *RateLimiter) GetLimit() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.limit
+}
+
+// WaitN blocks until n bytes may be transferred or the context is done. Waits
+// larger than the burst of one second are allowed and paid back afterwards.
+func (l *RateLimiter) WaitN(ctx context.Context, n int) error {
+	l.mu.Lock()
+	if l.limit <= 0 {
+		l.mu.Unlock()
+		return nil
+	}
+	now := time.Now()
+	l.refill(now)
+	l.tokens -= float64(n)
+	var wait time.Duration
+	if l.tokens < 0 {
+		wait = time.Duration(-l.tokens / float64(l.limit) * float64(time.Second))
+	}
+	l.mu.Unlock()
+
+	if wait == 0 {
+		return nil
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		l.mu.Lock()
+		l.tokens += float64(n)
+		l.mu.Unlock()
+		return ctx.Err()
+	}
+}
+
+func (l *RateLimiter) refill(now time.Time) {
+	l.tokens += now.Sub(l.last).Seconds() * float64(l.limit)
+	if l.tokens > float64(l.limit) {
+		l.tokens = float64(l.limit)
+	}
+	l.last = now
+}
+
+// waitAll waits on each limiter in turn, nil limiters are skipped
+func waitAll(ctx context.Context, n int, limiters ...*RateLimiter) error {
+	for _, l := range limiters {
+		if l == nil {
+			continue
+		}
+		err := l.WaitN(ctx, n)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
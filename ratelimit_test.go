@@ -0,0 +1,109 @@
+package torrentclient
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+func Test_RateLimiter(t *testing.T) {
+	ctx := context.Background()
+
+	l := NewRateLimiter(0)
+	if err := l.WaitN(ctx, 1<<30); err != nil {
+		t.Fatal(err)
+	}
+
+	l = NewRateLimiter(1000)
+	if err := l.WaitN(ctx, 1000); err != nil {
+		t.Fatal(err)
+	}
+
+	tctx, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	err := l.WaitN(tctx, 1000)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected the wait to exceed the deadline, got %v", err)
+	}
+}
+
+func Test_PeerLimiters(t *testing.T) {
+	torrent, data := newTestTorrent(t, 16, 20)
+	for i := range torrent.Pieces {
+		_, err := torrent.pieceDownloaded(i, data[i*16:min((i+1)*16, len(data))])
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	client := torrent.client
+	client.SetLocalLimits(0, 0, true)
+
+	remote := &Peer{torrent: torrent, Addr: netip.MustParseAddrPort("203.0.113.1:6881"), choked: true}
+	local := &Peer{torrent: torrent, Addr: netip.MustParseAddrPort("192.168.1.2:6881"), choked: true}
+	for _, peer := range []*Peer{remote, local} {
+		a, b := net.Pipe()
+		defer a.Close()
+		defer b.Close()
+		go io.Copy(io.Discard, b)
+		peer.conn = a
+	}
+
+	download := func(peer *Peer) error {
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		payload := make([]byte, 8+16)
+		return peer.handlePiece(ctx, payload)
+	}
+	upload := func(peer *Peer) error {
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		payload := make([]byte, 12)
+		binary.BigEndian.PutUint32(payload[8:12], 16)
+		peer.choking = false
+		return peer.handleRequest(ctx, payload)
+	}
+	// exhausted returns a limiter of 10 bytes per second with its burst used,
+	// a transfer of 16 bytes has to wait for more than a second
+	exhausted := func() *RateLimiter {
+		l := NewRateLimiter(10)
+		l.WaitN(context.Background(), 10)
+		return l
+	}
+
+	torrent.downloadLimiter = exhausted()
+	torrent.uploadLimiter = exhausted()
+	if err := download(local); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("torrent download limit not applied: %v", err)
+	}
+	if err := upload(local); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("torrent upload limit not applied: %v", err)
+	}
+	torrent.SetDownloadLimit(0)
+	torrent.SetUploadLimit(0)
+
+	// the client limits apply to remote peers, local peers use their own
+	client.downloadLimiter = exhausted()
+	client.uploadLimiter = exhausted()
+	if err := download(remote); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("client download limit not applied: %v", err)
+	}
+	if err := upload(remote); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("client upload limit not applied: %v", err)
+	}
+	if err := download(local); err != nil {
+		t.Errorf("client download limit applied to a local peer: %v", err)
+	}
+	if err := upload(local); err != nil {
+		t.Errorf("client upload limit applied to a local peer: %v", err)
+	}
+
+	client.localDownloadLimiter = exhausted()
+	if err := download(local); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("local download limit not applied: %v", err)
+	}
+}
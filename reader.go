@@ -0,0 +1,140 @@
+package torrentclient
+
+import (
+	"errors"
+	"io"
+)
+
+// priorityReadahead is given to the pieces ahead of the position of a reader,
+// above any file priority
+const priorityReadahead FilePriority = PriorityHigh + 1
+
+const defaultReadahead = 8 << 20
+
+// FileReader reads a file of a torrent while it is downloading, reads block
+// until the pieces they need are verified. The pieces ahead of the position of
+// the reader are downloaded first so sequential reads do not stall.
+type FileReader struct {
+	torrent   *Torrent
+	offset    int64
+	length    int64
+	pos       int64
+	readahead int64
+	closed    bool
+}
+
+var _ io.ReadSeekCloser = (*FileReader)(nil)
+
+// OpenFile returns a reader over the file at index
+func (torrent *Torrent) OpenFile(index int) (*FileReader, error) {
+	torrent.mu.Lock()
+	defer torrent.mu.Unlock()
+	if index < 0 || index >= len(torrent.Files) {
+		return nil, errors.New("file index out of range")
+	}
+	r := &FileReader{
+		torrent:   torrent,
+		offset:    torrent.fileOffset(index),
+		length:    int64(torrent.Files[index].Length),
+		readahead: defaultReadahead,
+	}
+	torrent.readers[r] = struct{}{}
+	torrent.updatePiecePriorities()
+	return r, nil
+}
+
+// SetReadahead sets how many bytes ahead of the position are prioritized
+func (r *FileReader) SetReadahead(n int64) {
+	r.torrent.mu.Lock()
+	r.readahead = n
+	r.torrent.updatePiecePriorities()
+	r.torrent.mu.Unlock()
+}
+
+// Read reads from the file, blocking until the data is available
+func (r *FileReader) Read(p []byte) (int, error) {
+	torrent := r.torrent
+	torrent.mu.Lock()
+	if r.closed {
+		torrent.mu.Unlock()
+		return 0, errors.New("reader closed")
+	}
+	if r.pos >= r.length {
+		torrent.mu.Unlock()
+		return 0, io.EOF
+	}
+	off := r.offset + r.pos
+	if int64(len(p)) > r.length-r.pos {
+		p = p[:r.length-r.pos]
+	}
+	index := int(off / int64(torrent.PieceLength))
+	pieceEnd := torrent.pieceOffset(index) + int64(torrent.pieceLength(index))
+	if off+int64(len(p)) > pieceEnd {
+		p = p[:pieceEnd-off]
+	}
+	for !torrent.Pieces[index].Complete && !r.closed {
+		torrent.pieceCond.Wait()
+	}
+	if r.closed {
+		torrent.mu.Unlock()
+		return 0, errors.New("reader closed")
+	}
+	torrent.mu.Unlock()
+
+	n, err := torrent.storage.ReadAt(p, off)
+
+	torrent.mu.Lock()
+	first, _ := r.window()
+	r.pos += int64(n)
+	if next, _ := r.window(); next != first {
+		torrent.updatePiecePriorities()
+	}
+	torrent.mu.Unlock()
+	return n, err
+}
+
+// Seek sets the position of the next Read
+func (r *FileReader) Seek(offset int64, whence int) (int64, error) {
+	r.torrent.mu.Lock()
+	defer r.torrent.mu.Unlock()
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.pos
+	case io.SeekEnd:
+		offset += r.length
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+	r.pos = offset
+	r.torrent.updatePiecePriorities()
+	return offset, nil
+}
+
+// Close releases the reader and unblocks a pending Read
+func (r *FileReader) Close() error {
+	r.torrent.mu.Lock()
+	defer r.torrent.mu.Unlock()
+	r.closed = true
+	delete(r.torrent.readers, r)
+	r.torrent.updatePiecePriorities()
+	r.torrent.pieceCond.Broadcast()
+	return nil
+}
+
+// window returns the indexes of the first and the last piece ahead of the
+// position of the reader. The torrent must be locked.
+func (r *FileReader) window() (int, int) {
+	if r.pos >= r.length {
+		return 0, -1
+	}
+	end := r.pos + r.readahead
+	if end > r.length {
+		end = r.length
+	}
+	pieceLength := int64(r.torrent.PieceLength)
+	return int((r.offset + r.pos) / pieceLength), int((r.offset + end - 1) / pieceLength)
+}
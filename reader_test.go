@@ -0,0 +1,50 @@
+package torrentclient
+
+import (
+	"io"
+	"testing"
+)
+
+func Test_FileReader(t *testing.T) {
+	torrent, data := newTestTorrent(t, 16, 20, 30)
+
+	r, err := torrent.OpenFile(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	for i := range torrent.Pieces {
+		if torrent.Pieces[i].priority != priorityReadahead && i > 0 {
+			t.Errorf("piece %d ahead of the reader not prioritized", i)
+		}
+	}
+
+	done := make(chan []byte)
+	go func() {
+		b, err := io.ReadAll(r)
+		if err != nil {
+			t.Error(err)
+		}
+		done <- b
+	}()
+
+	for i := len(torrent.Pieces) - 1; i >= 0; i-- {
+		_, err := torrent.pieceDownloaded(i, data[i*16:min((i+1)*16, len(data))])
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if b := <-done; string(b) != string(data[20:]) {
+		t.Error("read data does not match the file")
+	}
+
+	_, err = r.Seek(-5, io.SeekEnd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := io.ReadAll(r)
+	if err != nil || string(b) != string(data[45:]) {
+		t.Error("read after seek does not match the file")
+	}
+}
@@ -0,0 +1,214 @@
+package torrentclient
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	bencode "github.com/tharindu96/bencode-go"
+)
+
+// ScrapeResult holds the swarm counts of a torrent reported by a tracker
+type ScrapeResult struct {
+	Seeders   int
+	Leechers  int
+	Completed int
+}
+
+// ErrScrapeNotSupported is returned when a scrape URL can not be derived
+// from the announce URL of a tracker
+var ErrScrapeNotSupported = errors.New("scrape not supported")
+
+const (
+	httpScrapeBatch = 50
+	udpScrapeBatch  = 74
+)
+
+// Scrape asks the tracker for the swarm counts of the info hashes, or of the
+// torrent of the tracker when none is given. The results are keyed by the
+// info hash as a string, hashes the tracker does not know are missing.
+func (tracker *Tracker) Scrape(ctx context.Context, infoHashes ...[]byte) (map[string]*ScrapeResult, error) {
+	if len(infoHashes) == 0 {
+		infoHashes = [][]byte{tracker.torrent.InfoHash}
+	}
+	ctx, cancel := context.WithTimeout(ctx, tracker.torrent.client.config.TrackerTimeout)
+	defer cancel()
+
+	results := make(map[string]*ScrapeResult)
+	batch := httpScrapeBatch
+	if tracker.trackerType == typeUDP {
+		batch = udpScrapeBatch
+	}
+	for i := 0; i < len(infoHashes); i += batch {
+		end := i + batch
+		if end > len(infoHashes) {
+			end = len(infoHashes)
+		}
+		var err error
+		switch tracker.trackerType {
+		case typeHTTP:
+			err = tracker.scrapeHTTP(ctx, infoHashes[i:end], results)
+		case typeUDP:
+			err = tracker.scrapeUDP(ctx, infoHashes[i:end], results)
+		default:
+			err = fmt.Errorf("%w: %s", ErrUnknownTrackerType, tracker.URL)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return results, nil
+}
+
+// scrapeURL replaces announce with scrape in the last path component of the
+// announce URL (BEP 48)
+func scrapeURL(announce string) (string, error) {
+	u, err := url.Parse(announce)
+	if err != nil {
+		return "", err
+	}
+	i := strings.LastIndex(u.Path, "/")
+	if i < 0 || !strings.HasPrefix(u.Path[i+1:], "announce") {
+		return "", ErrScrapeNotSupported
+	}
+	u.Path = u.Path[:i+1] + "scrape" + strings.TrimPrefix(u.Path[i+1:], "announce")
+	u.RawPath = ""
+	return u.String(), nil
+}
+
+func (tracker *Tracker) scrapeHTTP(ctx context.Context, infoHashes [][]byte, results map[string]*ScrapeResult) error {
+	client := tracker.torrent.client
+	u, err := scrapeURL(tracker.URL)
+	if err != nil {
+		return err
+	}
+	vals := url.Values{}
+	for _, h := range infoHashes {
+		vals.Add("info_hash", string(h))
+	}
+	sep := "?"
+	if strings.Contains(u, "?") {
+		sep = "&"
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u+sep+vals.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("User-Agent", client.config.UserAgent)
+	res, err := client.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	body, err := responseBody(res)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	bNode, err := bencode.BRead(bufio.NewReader(body))
+	if res.StatusCode != http.StatusOK {
+		return tracker.statusError(res, bNode, err)
+	}
+	if err != nil {
+		return err
+	}
+	bDict, err := bNode.GetDict()
+	if err != nil {
+		return err
+	}
+	if failureNode := bDict.Get("failure reason"); failureNode != nil {
+		reason, err := failureNode.GetString()
+		if err != nil {
+			return err
+		}
+		return &TrackerFailureError{
+			URL:    tracker.URL,
+			Reason: reason.ToString(),
+		}
+	}
+	filesNode := bDict.Get("files")
+	if filesNode == nil {
+		return errors.New("no files in scrape response")
+	}
+	files, err := filesNode.GetDict()
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		fileDict, err := f.Value.GetDict()
+		if err != nil {
+			return err
+		}
+		results[f.Key] = &ScrapeResult{
+			Seeders:   getDictInt(&fileDict, "complete"),
+			Leechers:  getDictInt(&fileDict, "incomplete"),
+			Completed: getDictInt(&fileDict, "downloaded"),
+		}
+	}
+	return nil
+}
+
+func (tracker *Tracker) scrapeUDP(ctx context.Context, infoHashes [][]byte, results map[string]*ScrapeResult) error {
+	conn, err := tracker.dialUDP(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	req := make([]byte, 16, 16+20*len(infoHashes))
+	binary.BigEndian.PutUint32(req[8:12], udpActionScrape)
+	for _, h := range infoHashes {
+		req = append(req, h...)
+	}
+
+	res, err := tracker.udpRequest(ctx, conn, req, udpActionScrape)
+	if err != nil {
+		return err
+	}
+	res = res[8:]
+	for i, h := range infoHashes {
+		if len(res) < (i+1)*12 {
+			return errors.New("udp tracker: short scrape response")
+		}
+		entry := res[i*12 : (i+1)*12]
+		results[string(h)] = &ScrapeResult{
+			Seeders:   int(binary.BigEndian.Uint32(entry[0:4])),
+			Completed: int(binary.BigEndian.Uint32(entry[4:8])),
+			Leechers:  int(binary.BigEndian.Uint32(entry[8:12])),
+		}
+	}
+	return nil
+}
+
+// getDictInt returns the integer at key in the dict, 0 if it is missing
+func getDictInt(dict *bencode.BDict, key string) int {
+	node := dict.Get(key)
+	if node == nil {
+		return 0
+	}
+	i, err := node.GetInteger()
+	if err != nil {
+		return 0
+	}
+	return i.ToInt()
+}
+
+// getDictString returns the string at key in the dict, "" if it is missing
+func getDictString(dict *bencode.BDict, key string) string {
+	node := dict.Get(key)
+	if node == nil {
+		return ""
+	}
+	s, err := node.GetString()
+	if err != nil {
+		return ""
+	}
+	return s.ToString()
+}
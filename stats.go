@@ -0,0 +1,197 @@
+package torrentclient
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Stats holds the transfer counters in bytes and the rates in bytes per
+// second. Payload is piece data, protocol is everything else on the wire.
+type Stats struct {
+	DownloadedPayload  int64
+	UploadedPayload    int64
+	DownloadedProtocol int64
+	UploadedProtocol   int64
+	Wasted             int64
+	DownloadRate       float64
+	UploadRate         float64
+}
+
+// TorrentStats holds the Stats of a torrent and its progress
+type TorrentStats struct {
+	Stats
+	Left  int64
+	Ratio float64
+	ETA   time.Duration
+}
+
+type transferStats struct {
+	downloaded        atomic.Int64
+	uploaded          atomic.Int64
+	downloadedPayload atomic.Int64
+	uploadedPayload   atomic.Int64
+	wasted            atomic.Int64
+	downloadRate      rateMeter
+	uploadRate        rateMeter
+}
+
+func (ts *transferStats) get() Stats {
+	downloadedPayload := ts.downloadedPayload.Load()
+	uploadedPayload := ts.uploadedPayload.Load()
+	return Stats{
+		DownloadedPayload:  downloadedPayload,
+		UploadedPayload:    uploadedPayload,
+		DownloadedProtocol: ts.downloaded.Load() - downloadedPayload,
+		UploadedProtocol:   ts.uploaded.Load() - uploadedPayload,
+		Wasted:             ts.wasted.Load(),
+		DownloadRate:       ts.downloadRate.rate(),
+		UploadRate:         ts.uploadRate.rate(),
+	}
+}
+
+// statsGroup is a set of counters a transfer is accounted in
+type statsGroup []*transferStats
+
+func (g statsGroup) addDownloaded(n int) {
+	for _, ts := range g {
+		ts.downloaded.Add(int64(n))
+	}
+}
+
+func (g statsGroup) addUploaded(n int) {
+	for _, ts := range g {
+		ts.uploaded.Add(int64(n))
+	}
+}
+
+func (g statsGroup) addDownloadedPayload(n int) {
+	for _, ts := range g {
+		ts.downloadedPayload.Add(int64(n))
+		ts.downloadRate.add(int64(n))
+	}
+}
+
+func (g statsGroup) addUploadedPayload(n int) {
+	for _, ts := range g {
+		ts.uploadedPayload.Add(int64(n))
+		ts.uploadRate.add(int64(n))
+	}
+}
+
+func (g statsGroup) addWasted(n int) {
+	for _, ts := range g {
+		ts.wasted.Add(int64(n))
+	}
+}
+
+// statsGroup returns the counters of the peer, its torrent and the client
+func (peer *Peer) statsGroup() statsGroup {
+	return statsGroup{&peer.stats, &peer.torrent.stats, &peer.torrent.client.stats}
+}
+
+// Stats returns the transfer statistics of the peer
+func (peer *Peer) Stats() Stats {
+	return peer.stats.get()
+}
+
+// Stats returns the transfer statistics and the progress of the torrent
+func (torrent *Torrent) Stats() TorrentStats {
+	stats := TorrentStats{
+		Stats: torrent.stats.get(),
+		Left:  torrent.bytesLeft(),
+	}
+	if stats.DownloadedPayload > 0 {
+		stats.Ratio = float64(stats.UploadedPayload) / float64(stats.DownloadedPayload)
+	}
+	if stats.DownloadRate > 0 {
+		stats.ETA = time.Duration(float64(stats.Left) / stats.DownloadRate * float64(time.Second))
+	}
+	return stats
+}
+
+// Stats returns the transfer statistics of the whole session
+func (tc *TorrentClient) Stats() Stats {
+	return tc.stats.get()
+}
+
+// bytesLeft returns the number of bytes of the pieces that are not complete
+func (torrent *Torrent) bytesLeft() int64 {
+	torrent.mu.Lock()
+	defer torrent.mu.Unlock()
+	if !torrent.hasMetadata() {
+		// the size is unknown, anything but 0 tells trackers we are no seed
+		return 1
+	}
+	var left int64
+	for i, p := range torrent.Pieces {
+		if !p.Complete {
+			left += int64(torrent.pieceLength(i))
+		}
+	}
+	return left
+}
+
+const rateWindow = 5
+
+// rateMeter measures a rate over the last rateWindow seconds
+type rateMeter struct {
+	mu      sync.Mutex
+	buckets [rateWindow]int64
+	last    int64
+}
+
+func (m *rateMeter) advance(now int64) {
+	if now-m.last >= rateWindow {
+		m.buckets = [rateWindow]int64{}
+	} else {
+		for s := m.last + 1; s <= now; s++ {
+			m.buckets[s%rateWindow] = 0
+		}
+	}
+	m.last = now
+}
+
+func (m *rateMeter) add(n int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now().Unix()
+	m.advance(now)
+	m.buckets[now%rateWindow] += n
+}
+
+// rate returns the average over the complete seconds of the window
+func (m *rateMeter) rate() float64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now().Unix()
+	m.advance(now)
+	var sum int64
+	for i, b := range m.buckets {
+		if int64(i) != now%rateWindow {
+			sum += b
+		}
+	}
+	return float64(sum) / (rateWindow - 1)
+}
+
+// statsConn counts every byte read from and written to a peer and records when
+// the last write happened, it is only written to by the goroutine of the peer
+type statsConn struct {
+	net.Conn
+	peer *Peer
+}
+
+func (c *statsConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.peer.statsGroup().addDownloaded(n)
+	return n, err
+}
+
+func (c *statsConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	c.peer.statsGroup().addUploaded(n)
+	c.peer.lastSent = time.Now()
+	return n, err
+}
@@ -0,0 +1,70 @@
+package torrentclient
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func Test_StatsConn(t *testing.T) {
+	torrent, data := newTestTorrent(t, 16, 20)
+	peer := &Peer{torrent: torrent}
+	a, b := net.Pipe()
+	defer a.Close()
+	defer b.Close()
+	conn := &statsConn{Conn: a, peer: peer}
+
+	go func() {
+		io.ReadFull(b, make([]byte, 10))
+		b.Write(make([]byte, 24))
+	}()
+	_, err := conn.Write(make([]byte, 10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = io.ReadFull(conn, make([]byte, 24))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 16 of the bytes read were a block of a piece
+	peer.statsGroup().addDownloadedPayload(16)
+
+	for name, stats := range map[string]Stats{
+		"peer":    peer.Stats(),
+		"torrent": torrent.Stats().Stats,
+		"client":  torrent.client.Stats(),
+	} {
+		if stats.UploadedProtocol != 10 || stats.DownloadedPayload != 16 || stats.DownloadedProtocol != 8 {
+			t.Errorf("%s: unexpected stats %+v", name, stats)
+		}
+	}
+
+	_, err = torrent.pieceDownloaded(0, data[:16])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if left := torrent.Stats().Left; left != 4 {
+		t.Errorf("unexpected bytes left %d", left)
+	}
+}
+
+func Test_RateMeter(t *testing.T) {
+	var m rateMeter
+	now := time.Now().Unix()
+	m.last = now
+	m.buckets[(now-1)%rateWindow] = 400
+	m.buckets[(now-2)%rateWindow] = 400
+	// the current second is not complete and is left out, unless a second
+	// passed since now was taken
+	m.buckets[now%rateWindow] = 1000
+	if r := m.rate(); r != 200 && r != 450 {
+		t.Errorf("unexpected rate %f", r)
+	}
+
+	// the buckets of a meter that saw nothing for the window are stale
+	m.last = now - 2*rateWindow
+	if r := m.rate(); r != 0 {
+		t.Errorf("stale buckets counted, rate %f", r)
+	}
+}
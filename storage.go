@@ -0,0 +1,275 @@
+package torrentclient
+
+import (
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// storage reads and writes the data of a torrent in the data directory,
+// offsets are relative to the start of the torrent. The data of skipped files
+// that share a piece with a wanted file is kept in a hidden part file, at its
+// torrent offset, so skipped files are never created.
+type storage struct {
+	torrent *Torrent
+	dir     string
+	mu      sync.Mutex
+	files   map[int]*os.File
+	part    *os.File
+}
+
+func newStorage(torrent *Torrent, dir string) *storage {
+	return &storage{
+		torrent: torrent,
+		dir:     dir,
+		files:   make(map[int]*os.File),
+	}
+}
+
+// filePath returns the path of the file at index on disk
+func (s *storage) filePath(index int) string {
+	f := s.torrent.Files[index]
+	if s.torrent.multiFile {
+		return filepath.Join(s.dir, s.torrent.Name, filepath.FromSlash(f.Path))
+	}
+	return filepath.Join(s.dir, filepath.FromSlash(f.Path))
+}
+
+func (s *storage) partPath() string {
+	return filepath.Join(s.dir, "."+hex.EncodeToString(s.torrent.InfoHash)+".parts")
+}
+
+func (s *storage) openFile(index int, create bool) (*os.File, error) {
+	if f, ok := s.files[index]; ok {
+		return f, nil
+	}
+	p := s.filePath(index)
+	flag := os.O_RDWR
+	if create {
+		err := os.MkdirAll(filepath.Dir(p), 0755)
+		if err != nil {
+			return nil, err
+		}
+		flag |= os.O_CREATE
+	}
+	file := s.torrent.Files[index]
+	f, err := os.OpenFile(p, flag, file.fileMode())
+	if err != nil {
+		return nil, err
+	}
+	if create && file.IsHidden() {
+		err = setHidden(p)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	s.files[index] = f
+	return f, nil
+}
+
+func (s *storage) openPart(create bool) (*os.File, error) {
+	if s.part != nil {
+		return s.part, nil
+	}
+	flag := os.O_RDWR
+	if create {
+		err := os.MkdirAll(s.dir, 0755)
+		if err != nil {
+			return nil, err
+		}
+		flag |= os.O_CREATE
+	}
+	f, err := os.OpenFile(s.partPath(), flag, 0644)
+	if err != nil {
+		return nil, err
+	}
+	s.part = f
+	return f, nil
+}
+
+// locate returns the file holding the data of the file at index and whether
+// it is the part file. A skipped file uses the part file unless it is already
+// on disk.
+func (s *storage) locate(index int, create bool) (*os.File, bool, error) {
+	s.torrent.mu.Lock()
+	skipped := s.torrent.Files[index].priority == PrioritySkip
+	s.torrent.mu.Unlock()
+	if !skipped {
+		f, err := s.openFile(index, create)
+		return f, false, err
+	}
+	f, err := s.openFile(index, false)
+	if err == nil {
+		return f, false, nil
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return nil, false, err
+	}
+	f, err = s.openPart(create)
+	return f, true, err
+}
+
+// each calls fn for every file region covered by length bytes at off
+func (s *storage) each(off int64, length int, fn func(index int, fileOff int64, start int, end int) error) error {
+	pos := 0
+	for _, r := range s.torrent.fileRanges(off, int64(length)) {
+		err := fn(r.File, r.Offset, pos, pos+int(r.Length))
+		if err != nil {
+			return err
+		}
+		pos += int(r.Length)
+	}
+	if pos < length {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
+
+// WriteAt writes p at the torrent offset off, creating the files as needed
+func (s *storage) WriteAt(p []byte, off int64) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	err := s.each(off, len(p), func(index int, fileOff int64, start int, end int) error {
+		if s.torrent.Files[index].IsPad() {
+			n += end - start
+			return nil
+		}
+		f, part, err := s.locate(index, true)
+		if err != nil {
+			return err
+		}
+		if part {
+			fileOff = off + int64(start)
+		}
+		w, err := f.WriteAt(p[start:end], fileOff)
+		n += w
+		return err
+	})
+	return n, err
+}
+
+// ReadAt reads len(p) bytes at the torrent offset off
+func (s *storage) ReadAt(p []byte, off int64) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	err := s.each(off, len(p), func(index int, fileOff int64, start int, end int) error {
+		if s.torrent.Files[index].IsPad() {
+			clear(p[start:end])
+			n += end - start
+			return nil
+		}
+		f, part, err := s.locate(index, false)
+		if err != nil {
+			return err
+		}
+		if part {
+			fileOff = off + int64(start)
+		}
+		r, err := f.ReadAt(p[start:end], fileOff)
+		n += r
+		return err
+	})
+	return n, err
+}
+
+// setFilePriority changes the priority of the file at index. When a skipped
+// file becomes wanted the data of its complete pieces is moved out of the
+// part file first.
+func (s *storage) setFilePriority(index int, priority FilePriority) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	torrent := s.torrent
+	torrent.mu.Lock()
+	old := torrent.Files[index].priority
+	torrent.mu.Unlock()
+
+	if old == PrioritySkip && priority != PrioritySkip {
+		err := s.unpart(index)
+		if err != nil {
+			return err
+		}
+	}
+
+	torrent.mu.Lock()
+	torrent.Files[index].priority = priority
+	torrent.updatePiecePriorities()
+	resume := !torrent.isWantedComplete()
+	torrent.mu.Unlock()
+
+	if resume && torrent.GetState() == StateSeeding {
+		torrent.setState(StateDownloading)
+	}
+	return nil
+}
+
+// unpart copies the data of the file at index held in the part file to the
+// file itself
+func (s *storage) unpart(index int) error {
+	part, err := s.openPart(false)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	torrent := s.torrent
+	fileStart := torrent.fileOffset(index)
+	fileEnd := fileStart + int64(torrent.Files[index].Length)
+
+	first, last := torrent.FilePieceRange(index)
+	for i := first; i <= last; i++ {
+		start := torrent.pieceOffset(i)
+		end := start + int64(torrent.pieceLength(i))
+		if !torrent.hasPiece(i) {
+			continue
+		}
+		if start < fileStart {
+			start = fileStart
+		}
+		if end > fileEnd {
+			end = fileEnd
+		}
+		buff := make([]byte, end-start)
+		_, err := part.ReadAt(buff, start)
+		if err != nil {
+			return err
+		}
+		f, err := s.openFile(index, true)
+		if err != nil {
+			return err
+		}
+		_, err = f.WriteAt(buff, start-fileStart)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *storage) close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var err error
+	for i, f := range s.files {
+		if cerr := f.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+		delete(s.files, i)
+	}
+	if s.part != nil {
+		if cerr := s.part.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+		s.part = nil
+	}
+	return err
+}
@@ -0,0 +1,93 @@
+package torrentclient
+
+import (
+	"crypto/sha1"
+	"math/rand"
+	"os"
+	"testing"
+)
+
+// newTestTorrent returns a multi-file torrent with random content stored in a
+// temporary data directory, along with the content
+func newTestTorrent(t *testing.T, pieceLength uint, lengths ...uint) (*Torrent, []byte) {
+	config := DefaultConfig()
+	config.DataDir = t.TempDir()
+	client, err := NewClientWithConfig(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var total uint
+	torrent := newTorrent(client)
+	torrent.InfoHash = make([]byte, 20)
+	torrent.Name = "test"
+	torrent.PieceLength = pieceLength
+	torrent.multiFile = true
+	files := make([]*File, 0, len(lengths))
+	for i, l := range lengths {
+		files = append(files, &File{
+			Length:   l,
+			Path:     "dir/" + string(rune('a'+i)),
+			priority: PriorityNormal,
+		})
+		total += l
+	}
+	torrent.setFiles(files)
+
+	data := make([]byte, total)
+	rand.New(rand.NewSource(1)).Read(data)
+	for off := uint(0); off < total; off += pieceLength {
+		end := off + pieceLength
+		if end > total {
+			end = total
+		}
+		sum := sha1.Sum(data[off:end])
+		torrent.Pieces = append(torrent.Pieces, &Piece{Hash: string(sum[:])})
+	}
+	torrent.updatePiecePriorities()
+	t.Cleanup(func() {
+		torrent.storage.close()
+	})
+	return torrent, data
+}
+
+func Test_SkippedFiles(t *testing.T) {
+	torrent, data := newTestTorrent(t, 16, 24, 24)
+
+	err := torrent.SetFilePriority(1, PrioritySkip)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// piece 1 is shared by both files, piece 2 only covers the skipped one
+	if priority, err := torrent.GetFilePriority(1); priority != PrioritySkip || err != nil {
+		t.Fatal(priority, err)
+	}
+	if _, err := torrent.GetFilePriority(2); err == nil {
+		t.Error("out of range file index accepted")
+	}
+	if torrent.Pieces[1].priority == PrioritySkip || torrent.Pieces[2].priority != PrioritySkip {
+		t.Fatal("piece priorities not derived from the files")
+	}
+	for i := 0; i < 2; i++ {
+		ok, err := torrent.pieceDownloaded(i, data[i*16:(i+1)*16])
+		if !ok || err != nil {
+			t.Fatal(ok, err)
+		}
+	}
+	if _, err := os.Stat(torrent.storage.filePath(1)); !os.IsNotExist(err) {
+		t.Fatal("skipped file created on disk")
+	}
+
+	err = torrent.SetFilePriority(1, PriorityNormal)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(torrent.storage.filePath(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != string(data[24:32]) {
+		t.Error("data of the shared piece not moved out of the part file")
+	}
+}
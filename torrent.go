@@ -2,12 +2,18 @@ package torrentclient
 
 import (
 	"bufio"
+	"bytes"
+	"context"
 	"crypto/sha1"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
+	"math/rand/v2"
+	"net/http"
 	"os"
 	"path"
+	"sync"
 
 	"github.com/tharindu96/bencode-go"
 )
@@ -18,55 +24,247 @@ type Torrent struct {
 	InfoHash    []byte
 	Name        string
 	Trackers    []*Tracker
+	Tiers       [][]*Tracker
+	WebSeeds    []*WebSeed
+	HTTPSeeds   []*HTTPSeed
 	PieceLength uint
+	Private     bool
+	MetaInfo    *MetaInfo
 	Pieces      []*Piece
 	Files       []*File
+	// fileOffsets holds the torrent offset each file starts at, followed
+	// by the total length
+	fileOffsets []int64
 	Peers       map[string]*Peer
+	mu          sync.Mutex
+	pieceCond   *sync.Cond
+	readers     map[*FileReader]struct{}
+	state       TorrentState
+	stop        context.CancelFunc
+	stopped     chan struct{}
+	// completions counts the completed downloads, completeNotify is closed
+	// on the next one
+	completions    int
+	completeNotify chan struct{}
+	storage        *storage
+	multiFile      bool
+	connections    int
+	downloading    int
+	uploading      int
+
+	downloadLimiter *RateLimiter
+	uploadLimiter   *RateLimiter
+	stats           transferStats
+}
+
+// TorrentState is the state of a torrent
+type TorrentState uint
+
+// TorrentState Constants
+const (
+	StateStopped     TorrentState = 0
+	StateDownloading TorrentState = 1
+	StateSeeding     TorrentState = 2
+)
+
+func (state TorrentState) String() string {
+	switch state {
+	case StateStopped:
+		return "stopped"
+	case StateDownloading:
+		return "downloading"
+	case StateSeeding:
+		return "seeding"
+	default:
+		return "unknown"
+	}
 }
 
 // File struct
 type File struct {
 	Length uint
 	Path   string
+	// Attr holds the BEP 47 attributes: p pad file, x executable, h hidden
+	// and l symlink to SymlinkPath, relative to the torrent root
+	Attr        string
+	SymlinkPath string
+	priority    FilePriority
 }
 
+// maxTorrentFileSize is the largest .torrent file AddTorrentFromURL accepts
+const maxTorrentFileSize = 16 << 20
+
 // AddTorrentFromFile returns a new Torrent Object
-func (client *TorrentClient) AddTorrentFromFile(filepath string) (*Torrent, error) {
+func (client *TorrentClient) AddTorrentFromFile(ctx context.Context, filepath string) (*Torrent, error) {
 	f, err := os.Open(filepath)
 	if err != nil {
 		return nil, err
 	}
 	defer f.Close()
-	reader := bufio.NewReader(f)
-	bnode, err := bencode.BRead(reader)
+	return client.AddTorrentFromReader(ctx, f)
+}
+
+// AddTorrentFromBytes adds the torrent of the .torrent file content b
+func (client *TorrentClient) AddTorrentFromBytes(ctx context.Context, b []byte) (*Torrent, error) {
+	return client.AddTorrentFromReader(ctx, bytes.NewReader(b))
+}
+
+// AddTorrentFromURL downloads a .torrent file over HTTP and adds its torrent,
+// files larger than 16 MiB are refused
+func (client *TorrentClient) AddTorrentFromURL(ctx context.Context, u string) (*Torrent, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
 	if err != nil {
 		return nil, err
 	}
-	btordict, err := bnode.GetDict()
+	req.Header.Set("User-Agent", client.config.UserAgent)
+	res, err := client.httpClient.Do(req)
 	if err != nil {
 		return nil, err
 	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("torrent %s: unexpected status %s", u, res.Status)
+	}
+	if res.ContentLength > maxTorrentFileSize {
+		return nil, fmt.Errorf("torrent %s: file of %d bytes is too large", u, res.ContentLength)
+	}
+	b, err := io.ReadAll(io.LimitReader(&contextReader{ctx: ctx, r: res.Body}, maxTorrentFileSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(b) > maxTorrentFileSize {
+		return nil, fmt.Errorf("torrent %s: file is too large", u)
+	}
+	return client.AddTorrentFromBytes(ctx, b)
+}
 
-	torrent := &Torrent{
-		client: client,
-		Peers:  make(map[string]*Peer),
+// AddTorrentFromReader reads a .torrent file from r and adds its torrent. A
+// torrent that was already added is returned as is, unless it was added from
+// its info hash only, then it gets the metadata read.
+func (client *TorrentClient) AddTorrentFromReader(ctx context.Context, r io.Reader) (*Torrent, error) {
+	reader := bufio.NewReader(&contextReader{ctx: ctx, r: r})
+	bnode, err := bencode.BRead(reader)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, fmt.Errorf("%w: %w", ErrInvalidTorrent, err)
+	}
+	btordict, err := bnode.GetDict()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidTorrent, err)
 	}
 
+	torrent := newTorrent(client)
+
 	ok, err := parseTorrent(&btordict, torrent)
 
 	if !ok {
-		return nil, err
+		return nil, fmt.Errorf("%w: %w", ErrInvalidTorrent, err)
 	}
 
+	existing, added := client.addTorrentIfAbsent(torrent)
+	if !added {
+		existing.setMetadata(torrent)
+	}
+	return existing, nil
+}
+
+// AddTorrentFromInfoHash adds a torrent known only by its info hash, like
+// from a magnet link, with the trackers given as a single tier. It has no
+// metadata until it is added again from its .torrent file.
+func (client *TorrentClient) AddTorrentFromInfoHash(infoHash []byte, trackers ...string) (*Torrent, error) {
+	if len(infoHash) != 20 {
+		return nil, fmt.Errorf("%w: info hash of %d bytes", ErrInvalidTorrent, len(infoHash))
+	}
+	torrent := newTorrent(client)
+	torrent.InfoHash = append([]byte(nil), infoHash...)
+	torrent.Name = hex.EncodeToString(infoHash)
+	tier := make([]*Tracker, 0, len(trackers))
+	for _, u := range trackers {
+		t := NewTracker(u, 0, torrent)
+		if !trackerInTrackerList(t, tier) {
+			tier = append(tier, t)
+		}
+	}
+	if len(tier) > 0 {
+		torrent.Tiers = [][]*Tracker{tier}
+	}
+	torrent.Trackers = flattenTiers(torrent.Tiers)
+
+	torrent, _ = client.addTorrentIfAbsent(torrent)
 	return torrent, nil
 }
 
-// GetSize returns the size of the torrent in bytes
-func (torrent *Torrent) GetSize() uint {
-	if torrent.Pieces == nil {
-		return 0
+// setMetadata copies the info of the parsed torrent if the torrent has none
+// yet, its trackers that are not known yet are added as new tiers
+func (torrent *Torrent) setMetadata(parsed *Torrent) {
+	torrent.mu.Lock()
+	defer torrent.mu.Unlock()
+	if torrent.hasMetadata() {
+		return
+	}
+	torrent.Name = parsed.Name
+	torrent.MetaInfo = parsed.MetaInfo
+	torrent.Private = parsed.Private
+	torrent.PieceLength = parsed.PieceLength
+	torrent.Pieces = parsed.Pieces
+	torrent.setFiles(parsed.Files)
+	torrent.multiFile = parsed.multiFile
+	for _, ws := range parsed.WebSeeds {
+		ws.torrent = torrent
+	}
+	for _, hs := range parsed.HTTPSeeds {
+		hs.torrent = torrent
+	}
+	torrent.WebSeeds = parsed.WebSeeds
+	torrent.HTTPSeeds = parsed.HTTPSeeds
+	for _, tier := range parsed.Tiers {
+		added := make([]*Tracker, 0, len(tier))
+		for _, t := range tier {
+			if !trackerInTrackerList(t, torrent.Trackers) {
+				t.torrent = torrent
+				t.Tier = len(torrent.Tiers)
+				added = append(added, t)
+			}
+		}
+		if len(added) > 0 {
+			torrent.Tiers = append(torrent.Tiers, added)
+			torrent.Trackers = append(torrent.Trackers, added...)
+		}
+	}
+	torrent.updatePiecePriorities()
+}
+
+// HasMetadata returns whether the info of the torrent is known
+func (torrent *Torrent) HasMetadata() bool {
+	torrent.mu.Lock()
+	defer torrent.mu.Unlock()
+	return torrent.hasMetadata()
+}
+
+// hasMetadata is HasMetadata for callers holding the torrent lock
+func (torrent *Torrent) hasMetadata() bool {
+	return torrent.Pieces != nil
+}
+
+func newTorrent(client *TorrentClient) *Torrent {
+	torrent := &Torrent{
+		client:          client,
+		Peers:           make(map[string]*Peer),
+		readers:         make(map[*FileReader]struct{}),
+		downloadLimiter: NewRateLimiter(0),
+		uploadLimiter:   NewRateLimiter(0),
 	}
-	return uint(len(torrent.Pieces)) * torrent.PieceLength
+	torrent.pieceCond = sync.NewCond(&torrent.mu)
+	torrent.storage = newStorage(torrent, client.config.DataDir)
+	return torrent
+}
+
+// GetSize returns the size of the torrent in bytes, the sum of the lengths of
+// its files
+func (torrent *Torrent) GetSize() uint {
+	return uint(torrent.totalLength())
 }
 
 // GetClient returns the torrent client object
@@ -74,25 +272,162 @@ func (torrent *Torrent) GetClient() *TorrentClient {
 	return torrent.client
 }
 
-// RequestTrackers requests trackers and update the peer list
-func (torrent *Torrent) RequestTrackers(single bool) {
-	for _, t := range torrent.Trackers {
-		peers, err := t.requestPeers()
-		if err != nil || peers == nil {
-			continue
+// GetState returns the current state of the torrent
+func (torrent *Torrent) GetState() TorrentState {
+	torrent.mu.Lock()
+	defer torrent.mu.Unlock()
+	return torrent.state
+}
+
+func (torrent *Torrent) setState(state TorrentState) {
+	torrent.mu.Lock()
+	old := torrent.state
+	torrent.state = state
+	torrent.mu.Unlock()
+	if old == state {
+		return
+	}
+	torrent.client.events.emit(StateChangedEvent{
+		torrentEvent: torrentEvent{torrent},
+		Old:          old,
+		New:          state,
+	})
+}
+
+// SetDownloadLimit sets the download limit of the torrent in bytes per second
+func (torrent *Torrent) SetDownloadLimit(limit int) {
+	torrent.downloadLimiter.SetLimit(limit)
+}
+
+// SetUploadLimit sets the upload limit of the torrent in bytes per second
+func (torrent *Torrent) SetUploadLimit(limit int) {
+	torrent.uploadLimiter.SetLimit(limit)
+}
+
+// GetDownloadLimit returns the download limit of the torrent in bytes per second
+func (torrent *Torrent) GetDownloadLimit() int {
+	return torrent.downloadLimiter.GetLimit()
+}
+
+// GetUploadLimit returns the upload limit of the torrent in bytes per second
+func (torrent *Torrent) GetUploadLimit() int {
+	return torrent.uploadLimiter.GetLimit()
+}
+
+func (torrent *Torrent) acquireUploadSlot() bool {
+	torrent.mu.Lock()
+	defer torrent.mu.Unlock()
+	if torrent.uploading >= torrent.client.config.UploadSlots {
+		return false
+	}
+	torrent.uploading++
+	return true
+}
+
+func (torrent *Torrent) releaseUploadSlot() {
+	torrent.mu.Lock()
+	torrent.uploading--
+	torrent.mu.Unlock()
+}
+
+// RequestTrackers announces to one tracker of every tier and updates the peer
+// list, only the first tier that answers is used when single is set. An error
+// is returned if no tracker could be reached.
+func (torrent *Torrent) RequestTrackers(ctx context.Context, single bool) error {
+	errs := make([]error, 0)
+	announced := false
+	for i, n := 0, torrent.tierCount(); i < n; i++ {
+		_, err := torrent.announceTier(ctx, i, func(*Tracker) announceEvent {
+			return eventStarted
+		})
+		if ctx.Err() != nil {
+			return ctx.Err()
 		}
-		for _, p := range peers {
-			torrent.Peers[fmt.Sprintf("%s:%d", p.IP, p.Port)] = p
+		if err != nil {
+			errs = append(errs, err)
+			continue
 		}
+		announced = true
 		if single {
 			break
 		}
 	}
+	if announced {
+		return nil
+	}
+	return errors.Join(errs...)
+}
+
+// tierCount returns the number of tracker tiers
+func (torrent *Torrent) tierCount() int {
+	torrent.mu.Lock()
+	defer torrent.mu.Unlock()
+	return len(torrent.Tiers)
+}
+
+// announceTier tries the trackers of the tier in order until one answers, it
+// is moved to the front of the tier and returned. event gives the event to
+// send to each tracker.
+func (torrent *Torrent) announceTier(ctx context.Context, tier int, event func(*Tracker) announceEvent) (*Tracker, error) {
+	torrent.mu.Lock()
+	trackers := append([]*Tracker(nil), torrent.Tiers[tier]...)
+	torrent.mu.Unlock()
+
+	errs := make([]error, 0, len(trackers))
+	for _, t := range trackers {
+		_, err := torrent.announce(ctx, t, event(t))
+		if ctx.Err() != nil {
+			return t, ctx.Err()
+		}
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		torrent.promoteTracker(t)
+		return t, nil
+	}
+	return nil, errors.Join(errs...)
+}
+
+// promoteTracker moves the tracker to the front of its tier
+func (torrent *Torrent) promoteTracker(t *Tracker) {
+	torrent.mu.Lock()
+	defer torrent.mu.Unlock()
+	tier := torrent.Tiers[t.Tier]
+	for i, b := range tier {
+		if b == t {
+			copy(tier[1:i+1], tier[:i])
+			tier[0] = t
+			return
+		}
+	}
+}
+
+// announce sends an announce to the tracker and adds the peers it returns
+func (torrent *Torrent) announce(ctx context.Context, t *Tracker, event announceEvent) ([]*Peer, error) {
+	peers, err := t.requestPeers(ctx, event)
+	if err != nil {
+		if ctx.Err() == nil {
+			torrent.client.events.emit(TrackerErrorEvent{
+				torrentEvent: torrentEvent{torrent},
+				Tracker:      t,
+				Err:          err,
+			})
+		}
+		return nil, err
+	}
+	torrent.client.events.emit(TrackerAnnouncedEvent{
+		torrentEvent: torrentEvent{torrent},
+		Tracker:      t,
+		Peers:        len(peers),
+	})
+	torrent.addPeers(peers, sourceTracker)
+	return peers, nil
 }
 
 func parseTorrent(tordict *bencode.BDict, torrent *Torrent) (bool, error) {
 
-	trackers, err := getTrackerList(tordict, torrent)
+	tiers, err := getTrackerList(tordict, torrent)
 	if err != nil {
 		return false, err
 	}
@@ -110,8 +445,28 @@ func parseTorrent(tordict *bencode.BDict, torrent *Torrent) (bool, error) {
 		return false, err
 	}
 
+	webSeeds, err := getWebSeeds(tordict, torrent)
+	if err != nil {
+		return false, err
+	}
+	httpSeeds, err := getHTTPSeeds(tordict, torrent)
+	if err != nil {
+		return false, err
+	}
+	metaInfo, err := parseMetaInfo(tordict)
+	if err != nil {
+		return false, err
+	}
+	if problems := metaInfo.Validate(); len(problems) > 0 {
+		return false, errors.Join(problems...)
+	}
+
 	torrent.InfoHash = infoHash
-	torrent.Trackers = trackers
+	torrent.MetaInfo = metaInfo
+	torrent.Tiers = tiers
+	torrent.Trackers = flattenTiers(tiers)
+	torrent.WebSeeds = webSeeds
+	torrent.HTTPSeeds = httpSeeds
 
 	return parseTorrentInfo(&infoDict, torrent)
 }
@@ -136,13 +491,20 @@ func parseTorrentInfo(infodict *bencode.BDict, torrent *Torrent) (bool, error) {
 	torrent.Name = name
 	torrent.PieceLength = pieceLength
 	torrent.Pieces = pieces
-	torrent.Files = files
+	torrent.setFiles(files)
+	torrent.Private = getDictInt(infodict, "private") == 1
+	torrent.multiFile = infodict.Get("files") != nil
+	torrent.updatePiecePriorities()
 	return true, nil
 }
 
-func getTrackerList(tordict *bencode.BDict, torrent *Torrent) ([]*Tracker, error) {
+// getTrackerList returns the trackers grouped by tier (BEP 12), the trackers
+// of a tier are shuffled. The announce entry is only used when there is no
+// announce-list.
+func getTrackerList(tordict *bencode.BDict, torrent *Torrent) ([][]*Tracker, error) {
+	tiers := make([][]*Tracker, 0)
+	all := make([]*Tracker, 0)
 	bannouncenode := tordict.Get("announce-list")
-	list := make([]*Tracker, 0)
 	if bannouncenode != nil {
 		bannouncelist, err := bannouncenode.GetList()
 		if err != nil {
@@ -153,16 +515,32 @@ func getTrackerList(tordict *bencode.BDict, torrent *Torrent) ([]*Tracker, error
 			if err != nil {
 				return nil, err
 			}
+			tier := make([]*Tracker, 0, len(tl))
 			for _, t := range tl {
 				ts, err := t.GetString()
 				if err != nil {
 					return nil, err
 				}
 				t := NewTracker(string(ts), 0, torrent)
-				list = append(list, t)
+				if trackerInTrackerList(t, all) {
+					continue
+				}
+				t.Tier = len(tiers)
+				tier = append(tier, t)
+				all = append(all, t)
+			}
+			if len(tier) == 0 {
+				continue
 			}
+			rand.Shuffle(len(tier), func(i, j int) {
+				tier[i], tier[j] = tier[j], tier[i]
+			})
+			tiers = append(tiers, tier)
 		}
 	}
+	if len(tiers) > 0 {
+		return tiers, nil
+	}
 	bannouncenode = tordict.Get("announce")
 	if bannouncenode == nil {
 		return nil, errors.New("announce entry not in the torrent file")
@@ -171,11 +549,16 @@ func getTrackerList(tordict *bencode.BDict, torrent *Torrent) ([]*Tracker, error
 	if err != nil {
 		return nil, err
 	}
-	t := NewTracker(string(ts), 0, torrent)
-	if !trackerInTrackerList(t, list) {
-		list = append([]*Tracker{t}, list...)
+	return [][]*Tracker{{NewTracker(string(ts), 0, torrent)}}, nil
+}
+
+// flattenTiers returns the trackers of the tiers in order
+func flattenTiers(tiers [][]*Tracker) []*Tracker {
+	list := make([]*Tracker, 0)
+	for _, tier := range tiers {
+		list = append(list, tier...)
 	}
-	return list, nil
+	return list
 }
 
 func getInfoHash(infoDictNode *bencode.BNode) ([]byte, error) {
@@ -243,18 +626,26 @@ func getFiles(infoDict *bencode.BDict) ([]*File, error) {
 	files := make([]*File, 0)
 	if bfilesList == nil {
 		blengthInteger := infoDict.Get("length")
+		if blengthInteger == nil {
+			return nil, errors.New("length entry not in the torrent file")
+		}
 		length, err := blengthInteger.GetInteger()
 		if err != nil {
 			return nil, err
 		}
 		bnameString := infoDict.Get("name")
+		if bnameString == nil {
+			return nil, errors.New("name entry not in the torrent file")
+		}
 		name, err := bnameString.GetString()
 		if err != nil {
 			return nil, err
 		}
 		f := &File{
-			Length: uint(length),
-			Path:   name.ToString(),
+			Length:   uint(length),
+			Path:     name.ToString(),
+			Attr:     getDictString(infoDict, "attr"),
+			priority: PriorityNormal,
 		}
 		files = append(files, f)
 	} else {
@@ -268,11 +659,17 @@ func getFiles(infoDict *bencode.BDict) ([]*File, error) {
 				return nil, err
 			}
 			blengthInteger := fDict.Get("length")
+			if blengthInteger == nil {
+				return nil, errors.New("file length entry not in the torrent file")
+			}
 			length, err := blengthInteger.GetInteger()
 			if err != nil {
 				return nil, err
 			}
 			bpathList := fDict.Get("path")
+			if bpathList == nil {
+				return nil, errors.New("file path entry not in the torrent file")
+			}
 			pathList, err := bpathList.GetList()
 			if err != nil {
 				return nil, err
@@ -287,8 +684,15 @@ func getFiles(infoDict *bencode.BDict) ([]*File, error) {
 			}
 			p := path.Join(plist...)
 			f := &File{
-				Length: uint(length),
-				Path:   p,
+				Length:      uint(length),
+				Path:        p,
+				Attr:        getDictString(&fDict, "attr"),
+				SymlinkPath: path.Join(getDictStrings(&fDict, "symlink path")...),
+				priority:    PriorityNormal,
+			}
+			if f.IsPad() {
+				// pad files are zeros that are never stored
+				f.priority = PrioritySkip
 			}
 			files = append(files, f)
 		}
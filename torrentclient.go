@@ -3,17 +3,96 @@ Package torrentclient is an implementation of a torrentclient in go
 */
 package torrentclient
 
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const maxRedirects = 5
+
 // TorrentClient struct
 type TorrentClient struct {
-	port uint16
-	id   string
+	port        uint16
+	id          string
+	config      *Config
+	events      *eventBus
+	mu          sync.RWMutex
+	torrents    map[string]*Torrent
+	connections int
+	httpClient  *http.Client
+	seedClient  *http.Client
+	key         uint32
+
+	downloadLimiter      *RateLimiter
+	uploadLimiter        *RateLimiter
+	localDownloadLimiter *RateLimiter
+	localUploadLimiter   *RateLimiter
+	separateLocalLimits  bool
+	stats                transferStats
 }
 
-// NewTorrentClient returns a new TorrentClient object
+// NewTorrentClient returns a new TorrentClient object with the default config
 func NewTorrentClient(id string, port uint16) *TorrentClient {
+	config := DefaultConfig()
+	config.PeerID = id
+	config.ListenAddr = fmt.Sprintf(":%d", port)
+	return newTorrentClient(config, port)
+}
+
+// NewClientWithConfig returns a new TorrentClient object using the config
+func NewClientWithConfig(config *Config) (*TorrentClient, error) {
+	err := config.Validate()
+	if err != nil {
+		return nil, err
+	}
+	port, err := parsePort(config.ListenAddr)
+	if err != nil {
+		return nil, err
+	}
+	c := *config
+	return newTorrentClient(&c, port), nil
+}
+
+func newTorrentClient(config *Config, port uint16) *TorrentClient {
+	key := make([]byte, 4)
+	if _, err := rand.Read(key); err != nil {
+		panic(fmt.Sprintf("generating tracker key: %v", err))
+	}
 	return &TorrentClient{
-		port: port,
-		id:   id,
+		port:       port,
+		id:         config.PeerID,
+		config:     config,
+		events:     newEventBus(),
+		torrents:   make(map[string]*Torrent),
+		httpClient: newHTTPClient(config.TrackerTimeout),
+		// seed downloads are throttled, they are timed out by fetchSeed
+		seedClient: newHTTPClient(0),
+		key:        binary.BigEndian.Uint32(key),
+
+		downloadLimiter:      NewRateLimiter(config.DownloadLimit),
+		uploadLimiter:        NewRateLimiter(config.UploadLimit),
+		localDownloadLimiter: NewRateLimiter(config.LocalDownloadLimit),
+		localUploadLimiter:   NewRateLimiter(config.LocalUploadLimit),
+		separateLocalLimits:  config.SeparateLocalLimits,
+	}
+}
+
+// newHTTPClient returns a client that follows a few redirects and gives up
+// after the timeout, 0 means no timeout
+func newHTTPClient(timeout time.Duration) *http.Client {
+	return &http.Client{
+		Timeout: timeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= maxRedirects {
+				return errors.New("too many redirects")
+			}
+			return nil
+		},
 	}
 }
 
@@ -26,3 +105,100 @@ func (tc *TorrentClient) GetPort() uint16 {
 func (tc *TorrentClient) GetID() string {
 	return tc.id
 }
+
+// GetConfig returns a copy of the config of the client
+func (tc *TorrentClient) GetConfig() Config {
+	return *tc.config
+}
+
+// SetDownloadLimit sets the download limit of the client in bytes per second
+func (tc *TorrentClient) SetDownloadLimit(limit int) {
+	tc.downloadLimiter.SetLimit(limit)
+}
+
+// SetUploadLimit sets the upload limit of the client in bytes per second
+func (tc *TorrentClient) SetUploadLimit(limit int) {
+	tc.uploadLimiter.SetLimit(limit)
+}
+
+// GetDownloadLimit returns the download limit of the client in bytes per second
+func (tc *TorrentClient) GetDownloadLimit() int {
+	return tc.downloadLimiter.GetLimit()
+}
+
+// GetUploadLimit returns the upload limit of the client in bytes per second
+func (tc *TorrentClient) GetUploadLimit() int {
+	return tc.uploadLimiter.GetLimit()
+}
+
+// SetLocalLimits sets the limits used for peers on the local network instead
+// of the client limits, enabled false makes them share the client limits
+func (tc *TorrentClient) SetLocalLimits(download int, upload int, enabled bool) {
+	tc.localDownloadLimiter.SetLimit(download)
+	tc.localUploadLimiter.SetLimit(upload)
+	tc.mu.Lock()
+	tc.separateLocalLimits = enabled
+	tc.mu.Unlock()
+}
+
+// GetTorrents returns the torrents added to the client
+func (tc *TorrentClient) GetTorrents() []*Torrent {
+	tc.mu.RLock()
+	defer tc.mu.RUnlock()
+	torrents := make([]*Torrent, 0, len(tc.torrents))
+	for _, t := range tc.torrents {
+		torrents = append(torrents, t)
+	}
+	return torrents
+}
+
+// addTorrentIfAbsent adds the torrent unless one with the same info hash was
+// already added, that one is returned with false then
+func (tc *TorrentClient) addTorrentIfAbsent(torrent *Torrent) (*Torrent, bool) {
+	tc.mu.Lock()
+	if existing := tc.torrents[string(torrent.InfoHash)]; existing != nil {
+		tc.mu.Unlock()
+		return existing, false
+	}
+	tc.torrents[string(torrent.InfoHash)] = torrent
+	tc.mu.Unlock()
+	tc.events.emit(TorrentAddedEvent{torrentEvent{torrent}})
+	return torrent, true
+}
+
+// acquireConnection reserves a connection slot for the torrent, false is
+// returned when the global or the per torrent limit is reached
+func (tc *TorrentClient) acquireConnection(torrent *Torrent) bool {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	if tc.connections >= tc.config.MaxConnections {
+		return false
+	}
+	torrent.mu.Lock()
+	defer torrent.mu.Unlock()
+	if torrent.connections >= tc.config.MaxConnectionsPerTorrent {
+		return false
+	}
+	tc.connections++
+	torrent.connections++
+	return true
+}
+
+func (tc *TorrentClient) releaseConnection(torrent *Torrent) {
+	tc.mu.Lock()
+	tc.connections--
+	tc.mu.Unlock()
+	torrent.mu.Lock()
+	torrent.connections--
+	torrent.mu.Unlock()
+}
+
+func (tc *TorrentClient) getLimiters(local bool) (download *RateLimiter, upload *RateLimiter) {
+	tc.mu.RLock()
+	separate := tc.separateLocalLimits
+	tc.mu.RUnlock()
+	if local && separate {
+		return tc.localDownloadLimiter, tc.localUploadLimiter
+	}
+	return tc.downloadLimiter, tc.uploadLimiter
+}
@@ -1,22 +1,36 @@
 package torrentclient
 
 import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
 	"testing"
+	"time"
 )
 
 func Test_Main(t *testing.T) {
 
 	client := NewTorrentClient("torrentclient-go", 6881)
 
-	torrent, err := client.AddTorrentFromFile("./tests/Clocks.torrent")
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	torrent, err := client.AddTorrentFromFile(ctx, "./tests/Clocks.torrent")
 	if err != nil {
-		t.Error(err)
+		t.Fatal(err)
 	}
 
-	torrent.RequestTrackers(true)
+	err = torrent.RequestTrackers(ctx, true)
+	if err != nil {
+		t.Fatal(err)
+	}
 
 	for _, v := range torrent.Peers {
-		v.Connect()
+		err = v.Connect(ctx)
+		if err != nil {
+			t.Log(err)
+		}
 	}
 
 	// log.Println(torrent.Trackers[0].RequestPeers(torrent, client))
@@ -64,3 +78,111 @@ func Test_Main(t *testing.T) {
 	// fmt.Println(u)
 
 }
+
+func Test_AddTorrentFromURL(t *testing.T) {
+	config := DefaultConfig()
+	config.DataDir = t.TempDir()
+	client, err := NewClientWithConfig(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mi := &MetaInfo{
+		Announce: "http://tracker/announce",
+		Info: InfoDict{
+			Name:        "a",
+			PieceLength: 16,
+			Pieces:      make([]byte, 20),
+			Length:      10,
+		},
+	}
+	b, err := mi.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	infoHash, err := mi.InfoHash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/large" {
+			w.Write(make([]byte, maxTorrentFileSize+1))
+			return
+		}
+		w.Write(b)
+	}))
+	defer server.Close()
+
+	magnet, err := client.AddTorrentFromInfoHash(infoHash, "http://other/announce")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if magnet.HasMetadata() {
+		t.Fatal("torrent added from its info hash has metadata")
+	}
+	if err := magnet.Recheck(context.Background()); err != nil || magnet.GetState() == StateSeeding {
+		t.Fatalf("torrent without metadata rechecked as complete: %v", err)
+	}
+	torrent, err := client.AddTorrentFromURL(context.Background(), server.URL+"/a.torrent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if torrent != magnet || !torrent.HasMetadata() || torrent.Name != "a" || len(torrent.Trackers) != 2 {
+		t.Errorf("metadata not added to the torrent: %v %s %d", torrent.HasMetadata(), torrent.Name, len(torrent.Trackers))
+	}
+
+	_, err = client.AddTorrentFromURL(context.Background(), server.URL+"/large")
+	if err == nil {
+		t.Error("too large torrent file accepted")
+	}
+	_, err = client.AddTorrentFromBytes(context.Background(), []byte("d4:infod4:name1:ae"))
+	if !errors.Is(err, ErrInvalidTorrent) {
+		t.Errorf("unexpected error %v", err)
+	}
+}
+
+func Test_AddTorrentConcurrently(t *testing.T) {
+	config := DefaultConfig()
+	config.DataDir = t.TempDir()
+	client, err := NewClientWithConfig(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mi := &MetaInfo{
+		Announce: "http://tracker/announce",
+		Info:     InfoDict{Name: "a", PieceLength: 16, Pieces: make([]byte, 20), Length: 10},
+	}
+	b, err := mi.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sub := client.Subscribe(nil, 64)
+	defer sub.Unsubscribe()
+
+	const n = 8
+	torrents := make(chan *Torrent, n)
+	for i := 0; i < n; i++ {
+		go func() {
+			torrent, err := client.AddTorrentFromBytes(context.Background(), b)
+			if err != nil {
+				t.Error(err)
+			}
+			torrents <- torrent
+		}()
+	}
+	first := <-torrents
+	for i := 1; i < n; i++ {
+		if <-torrents != first {
+			t.Fatal("the same torrent was added twice")
+		}
+	}
+	added := 0
+	for len(sub.C) > 0 {
+		if _, ok := (<-sub.C).(TorrentAddedEvent); ok {
+			added++
+		}
+	}
+	if added != 1 || len(client.GetTorrents()) != 1 {
+		t.Errorf("expected a single torrent, %d added events", added)
+	}
+}
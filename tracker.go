@@ -2,23 +2,46 @@ package torrentclient
 
 import (
 	"bufio"
+	"compress/gzip"
+	"context"
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"io"
+	"net"
 	"net/http"
+	"net/netip"
 	"net/url"
-	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	bencode "github.com/tharindu96/bencode-go"
 )
 
 // Tracker structure
 type Tracker struct {
-	torrent     *Torrent
-	URL         string
+	torrent *Torrent
+	URL     string
+	Tier    int
+	// mu guards the results of the last announce below, the tracker id and
+	// the UDP connection id
+	mu          sync.Mutex
 	Interval    int
+	MinInterval int
+	// Seeders and Leechers are the swarm counts of the last announce
+	Seeders  int
+	Leechers int
+	// Warning is the warning message of the last announce
+	Warning string
+	// ExternalIP is our address as seen by the tracker (BEP 24)
+	ExternalIP netip.Addr
+
 	trackerType trackerType
+	trackerID   string
+
+	udpConnID       uint64
+	udpConnIDExpiry time.Time
 }
 
 type trackerType uint
@@ -43,7 +66,7 @@ func NewTracker(u string, interval int, torrent *Torrent) *Tracker {
 	}
 
 	switch ul.Scheme {
-	case "http":
+	case "http", "https":
 		t.trackerType = typeHTTP
 		break
 	case "udp":
@@ -56,43 +79,100 @@ func NewTracker(u string, interval int, torrent *Torrent) *Tracker {
 	return t
 }
 
-func (tracker *Tracker) requestPeers() ([]*Peer, error) {
+// announceEvent is the event sent with an announce
+type announceEvent string
+
+// announceEvent Constants
+const (
+	eventNone      announceEvent = ""
+	eventStarted   announceEvent = "started"
+	eventCompleted announceEvent = "completed"
+	eventStopped   announceEvent = "stopped"
+)
+
+func (tracker *Tracker) requestPeers(ctx context.Context, event announceEvent) ([]*Peer, error) {
 	switch tracker.trackerType {
 	case typeHTTP:
-		return tracker.requestHTTPTracker()
+		return tracker.requestHTTPTracker(ctx, event)
 	case typeUDP:
-		return tracker.requestUDPTracker()
+		return tracker.requestUDPTracker(ctx, event)
 	default:
-		return nil, errors.New("unknown tracker type")
+		return nil, fmt.Errorf("%w: %s", ErrUnknownTrackerType, tracker.URL)
 	}
 }
 
-func (tracker *Tracker) requestUDPTracker() ([]*Peer, error) {
-	return nil, nil
-}
-
-func (tracker *Tracker) requestHTTPTracker() ([]*Peer, error) {
+func (tracker *Tracker) requestHTTPTracker(ctx context.Context, event announceEvent) ([]*Peer, error) {
 	torrent := tracker.torrent
 	client := torrent.GetClient()
 
+	config := client.config
+	stats := torrent.Stats()
 	vals := url.Values{}
 	vals.Set("info_hash", string(torrent.InfoHash))
-	vals.Set("peer_id", fmt.Sprintf("%20s", client.GetID()))
+	vals.Set("peer_id", padPeerID(client.GetID()))
 	vals.Set("port", fmt.Sprintf("%d", client.GetPort()))
-	vals.Set("uploaded", "0")
-	vals.Set("downloaded", "0")
-	vals.Set("left", fmt.Sprintf("%d", torrent.GetSize()))
-	vals.Set("event", "started")
+	vals.Set("uploaded", fmt.Sprintf("%d", stats.UploadedPayload))
+	vals.Set("downloaded", fmt.Sprintf("%d", stats.DownloadedPayload))
+	vals.Set("left", fmt.Sprintf("%d", stats.Left))
+	vals.Set("compact", "1")
+	vals.Set("no_peer_id", "1")
+	vals.Set("key", fmt.Sprintf("%08x", client.key))
+	if event == eventStopped {
+		vals.Set("numwant", "0")
+	} else {
+		vals.Set("numwant", fmt.Sprintf("%d", config.NumWant))
+	}
+	if event != eventNone {
+		vals.Set("event", string(event))
+	}
+	tracker.mu.Lock()
+	trackerID := tracker.trackerID
+	tracker.mu.Unlock()
+	if trackerID != "" {
+		vals.Set("trackerid", trackerID)
+	}
+	if config.AnnounceIP != "" {
+		vals.Set("ip", config.AnnounceIP)
+	}
+	ipv4, ipv6 := publicAddrs()
+	if ipv4.IsValid() {
+		vals.Set("ipv4", ipv4.String())
+	}
+	if ipv6.IsValid() {
+		vals.Set("ipv6", ipv6.String())
+	}
 	query := vals.Encode()
 
-	url := fmt.Sprintf("%s?%s", tracker.URL, query)
+	sep := "?"
+	if strings.Contains(tracker.URL, "?") {
+		sep = "&"
+	}
+	url := tracker.URL + sep + query
+
+	ctx, cancel := context.WithTimeout(ctx, config.TrackerTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", config.UserAgent)
 
-	res, err := http.Get(url)
+	res, err := client.httpClient.Do(req)
 	if err != nil {
 		return nil, err
 	}
+	defer res.Body.Close()
+	body, err := responseBody(res)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
 
-	bNode, err := bencode.BRead(bufio.NewReader(res.Body))
+	bNode, err := bencode.BRead(bufio.NewReader(body))
+	if res.StatusCode != http.StatusOK {
+		return nil, tracker.statusError(res, bNode, err)
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -108,49 +188,110 @@ func (tracker *Tracker) requestHTTPTracker() ([]*Peer, error) {
 		if err != nil {
 			return nil, err
 		}
-		return nil, errors.New(failureReasonString.ToString())
+		return nil, &TrackerFailureError{
+			URL:    tracker.URL,
+			Reason: failureReasonString.ToString(),
+		}
 	}
 
 	intervalNode := bNodeDict.Get("interval")
+	if intervalNode == nil {
+		return nil, errors.New("no interval")
+	}
 	intervalInt, err := intervalNode.GetInteger()
 	if err != nil {
 		return nil, err
 	}
+	var newTrackerID string
+	if trackerIDNode := bNodeDict.Get("tracker id"); trackerIDNode != nil {
+		s, err := trackerIDNode.GetString()
+		if err != nil {
+			return nil, err
+		}
+		newTrackerID = s.ToString()
+	}
+	tracker.mu.Lock()
 	tracker.Interval = intervalInt.ToInt()
+	tracker.MinInterval = getDictInt(&bNodeDict, "min interval")
+	if newTrackerID != "" {
+		tracker.trackerID = newTrackerID
+	}
+	tracker.Seeders = getDictInt(&bNodeDict, "complete")
+	tracker.Leechers = getDictInt(&bNodeDict, "incomplete")
+	tracker.Warning = getDictString(&bNodeDict, "warning message")
+	if ip, ok := netip.AddrFromSlice([]byte(getDictString(&bNodeDict, "external ip"))); ok {
+		tracker.ExternalIP = ip.Unmap()
+	}
+	tracker.mu.Unlock()
 
 	peersNode := bNodeDict.Get("peers")
-	if peersNode == nil {
+	peers6Node := bNodeDict.Get("peers6")
+	if peersNode == nil && peers6Node == nil {
 		return nil, errors.New("no peers")
 	}
 
-	peers, err := tracker.parsePeers(peersNode)
-	if err != nil {
-		return nil, err
+	peers := make([]*Peer, 0)
+	if peersNode != nil {
+		p, err := tracker.parsePeers(peersNode, compactPeerLength)
+		if err != nil {
+			return nil, err
+		}
+		peers = append(peers, p...)
+	}
+	if peers6Node != nil {
+		p, err := tracker.parsePeers(peers6Node, compactPeer6Length)
+		if err != nil {
+			return nil, err
+		}
+		peers = append(peers, p...)
 	}
 
 	return peers, nil
 }
 
-func (tracker *Tracker) parsePeers(peersNode *bencode.BNode) ([]*Peer, error) {
-	peers := make([]*Peer, 0)
+// responseBody returns the body of the response, gzip bodies the transport
+// did not decode are decompressed
+func responseBody(res *http.Response) (io.ReadCloser, error) {
+	if !res.Uncompressed && strings.EqualFold(res.Header.Get("Content-Encoding"), "gzip") {
+		return gzip.NewReader(res.Body)
+	}
+	return res.Body, nil
+}
+
+// statusError returns the error of a response that is not 200 OK, trackers
+// usually give the failure reason in a bencoded body. bNode and err are the
+// result of decoding the body.
+func (tracker *Tracker) statusError(res *http.Response, bNode *bencode.BNode, err error) error {
+	if err == nil {
+		if dict, err := bNode.GetDict(); err == nil {
+			if reason := getDictString(&dict, "failure reason"); reason != "" {
+				return &TrackerFailureError{
+					URL:    tracker.URL,
+					Reason: reason,
+				}
+			}
+		}
+	}
+	return fmt.Errorf("tracker %s: unexpected status %s", tracker.URL, res.Status)
+}
+
+const (
+	compactPeerLength  = 6
+	compactPeer6Length = 18
+)
+
+// parsePeers parses a list of peer dictionaries or a compact peer string with
+// entries of size bytes, invalid entries are skipped
+func (tracker *Tracker) parsePeers(peersNode *bencode.BNode, size int) ([]*Peer, error) {
 	if peersNode.Type == bencode.BencodeString {
 		peersbstring, err := peersNode.GetString()
 		if err != nil {
 			return nil, err
 		}
-
-		peersString := string(peersbstring)
-
-		peerCount := len(peersString) / 6
-
-		for i := 0; i < peerCount; i++ {
-			p, err := tracker.parseCompactPeer([]byte(peersString[i*6 : (i+1)*6]))
-			if err != nil {
-				return nil, err
-			}
-			peers = append(peers, p)
-		}
-	} else if peersNode.Type == bencode.BencodeList {
+		return tracker.parseCompactPeers([]byte(peersbstring), size), nil
+	}
+	peers := make([]*Peer, 0)
+	if peersNode.Type == bencode.BencodeList {
 		peersList, err := peersNode.GetList()
 		if err != nil {
 			return nil, err
@@ -158,7 +299,7 @@ func (tracker *Tracker) parsePeers(peersNode *bencode.BNode) ([]*Peer, error) {
 		for _, pn := range peersList {
 			p, err := tracker.parsePeer(pn)
 			if err != nil {
-				return nil, err
+				continue
 			}
 			peers = append(peers, p)
 		}
@@ -193,37 +334,73 @@ func (tracker *Tracker) parsePeer(peerNode *bencode.BNode) (*Peer, error) {
 	if err != nil {
 		return nil, err
 	}
+	addr, err := netip.ParseAddr(ip.ToString())
+	if err != nil {
+		return nil, err
+	}
 	peer := &Peer{
 		torrent: tracker.torrent,
 		ID:      id.ToString(),
-		IP:      ip.ToString(),
-		Port:    uint16(port.ToInt()),
+		Addr:    netip.AddrPortFrom(addr.Unmap(), uint16(port.ToInt())),
 	}
 	return peer, nil
 }
 
+// parseCompactPeers parses compact peer entries, 4 or 16 bytes of address
+// followed by 2 bytes of port
+func (tracker *Tracker) parseCompactPeers(b []byte, size int) []*Peer {
+	peers := make([]*Peer, 0, len(b)/size)
+	for i := 0; i+size <= len(b); i += size {
+		p, err := tracker.parseCompactPeer(b[i : i+size])
+		if err != nil {
+			continue
+		}
+		peers = append(peers, p)
+	}
+	return peers
+}
+
 func (tracker *Tracker) parseCompactPeer(peerb []byte) (*Peer, error) {
-	if len(peerb) != 6 {
+	if len(peerb) != compactPeerLength && len(peerb) != compactPeer6Length {
+		return nil, errors.New("invalid peer")
+	}
+	n := len(peerb) - 2
+	addr, _ := netip.AddrFromSlice(peerb[:n])
+	port := binary.BigEndian.Uint16(peerb[n:])
+	if port == 0 || !addr.IsValid() || addr.IsUnspecified() {
 		return nil, errors.New("invalid peer")
 	}
-	ipi := binary.BigEndian.Uint32(peerb[0:4])
-	ports := binary.BigEndian.Uint16(peerb[4:6])
-	ip := intToIP(int(ipi))
 	peer := &Peer{
 		torrent: tracker.torrent,
 		ID:      "",
-		IP:      ip,
-		Port:    ports,
+		Addr:    netip.AddrPortFrom(addr.Unmap(), port),
 	}
 	return peer, nil
 }
 
-func intToIP(ipi int) string {
-	parts := make([]string, 4)
-	for i := 0; i < 4; i++ {
-		p := 0xff & ipi
-		ipi = ipi >> 8
-		parts[4-i-1] = strconv.FormatInt(int64(p), 10)
+// publicAddrs returns the first global IPv4 and IPv6 addresses of the local
+// interfaces, they are sent to trackers so peers can reach us on both families
+func publicAddrs() (netip.Addr, netip.Addr) {
+	var ipv4, ipv6 netip.Addr
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return ipv4, ipv6
+	}
+	for _, a := range addrs {
+		prefix, err := netip.ParsePrefix(a.String())
+		if err != nil {
+			continue
+		}
+		addr := prefix.Addr()
+		if !addr.IsGlobalUnicast() || addr.IsPrivate() {
+			continue
+		}
+		if addr.Is4() && !ipv4.IsValid() {
+			ipv4 = addr
+		}
+		if addr.Is6() && !ipv6.IsValid() {
+			ipv6 = addr
+		}
 	}
-	return strings.Join(parts, ".")
+	return ipv4, ipv6
 }
@@ -0,0 +1,143 @@
+package tracker
+
+import (
+	"net"
+	"net/http"
+	"net/netip"
+	"path"
+	"strconv"
+
+	"github.com/tharindu96/torrentclient-go/internal/bencodeutil"
+)
+
+// ServeHTTP serves announces at .../announce and scrapes at .../scrape
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch path.Base(r.URL.Path) {
+	case "announce":
+		s.serveAnnounce(w, r)
+	case "scrape":
+		s.serveScrape(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (s *Server) serveAnnounce(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	req := &announceRequest{
+		infoHash: q.Get("info_hash"),
+		peerID:   q.Get("peer_id"),
+		event:    announceEvent(q.Get("event")),
+		numWant:  -1,
+	}
+	port, err := strconv.ParseUint(q.Get("port"), 10, 16)
+	if err != nil {
+		writeFailure(w, ErrInvalidRequest)
+		return
+	}
+	req.left, err = strconv.ParseInt(q.Get("left"), 10, 64)
+	if err != nil {
+		writeFailure(w, ErrInvalidRequest)
+		return
+	}
+	if n, err := strconv.Atoi(q.Get("numwant")); err == nil {
+		req.numWant = n
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		writeFailure(w, ErrInvalidRequest)
+		return
+	}
+	ip, err := netip.ParseAddr(host)
+	if err != nil {
+		writeFailure(w, ErrInvalidRequest)
+		return
+	}
+	req.addr = netip.AddrPortFrom(ip.Unmap(), uint16(port))
+
+	res, err := s.announce(req)
+	if err != nil {
+		writeFailure(w, err)
+		return
+	}
+
+	dict := map[string]interface{}{
+		"interval":     int(s.Interval.Seconds()),
+		"min interval": int(s.MinInterval.Seconds()),
+		"complete":     res.counts.Seeders,
+		"incomplete":   res.counts.Leechers,
+		"external ip":  string(ip.Unmap().AsSlice()),
+	}
+	if q.Get("compact") == "0" {
+		peers := make([]interface{}, 0, len(res.peers))
+		for _, p := range res.peers {
+			peer := map[string]interface{}{
+				"ip":   p.Addr.Addr().String(),
+				"port": int(p.Addr.Port()),
+			}
+			if q.Get("no_peer_id") != "1" {
+				peer["peer id"] = p.ID
+			}
+			peers = append(peers, peer)
+		}
+		dict["peers"] = peers
+	} else {
+		peers, peers6 := compactPeers(res.peers)
+		dict["peers"] = string(peers)
+		if len(peers6) > 0 {
+			dict["peers6"] = string(peers6)
+		}
+	}
+	writeDict(w, dict)
+}
+
+func (s *Server) serveScrape(w http.ResponseWriter, r *http.Request) {
+	infoHashes := r.URL.Query()["info_hash"]
+	if len(infoHashes) == 0 {
+		writeFailure(w, ErrInvalidRequest)
+		return
+	}
+	files, err := s.scrape(infoHashes)
+	if err != nil {
+		writeFailure(w, err)
+		return
+	}
+	dict := make(map[string]interface{})
+	for h, c := range files {
+		dict[h] = map[string]interface{}{
+			"complete":   c.Seeders,
+			"incomplete": c.Leechers,
+			"downloaded": c.Completed,
+		}
+	}
+	writeDict(w, map[string]interface{}{"files": dict})
+}
+
+// compactPeers returns the IPv4 and IPv6 peers in their compact forms
+func compactPeers(peers []Peer) ([]byte, []byte) {
+	v4 := make([]byte, 0)
+	v6 := make([]byte, 0)
+	for _, p := range peers {
+		b := append(p.Addr.Addr().AsSlice(), byte(p.Addr.Port()>>8), byte(p.Addr.Port()))
+		if p.Addr.Addr().Is4() {
+			v4 = append(v4, b...)
+		} else {
+			v6 = append(v6, b...)
+		}
+	}
+	return v4, v6
+}
+
+func writeFailure(w http.ResponseWriter, err error) {
+	writeDict(w, map[string]interface{}{"failure reason": err.Error()})
+}
+
+func writeDict(w http.ResponseWriter, dict map[string]interface{}) {
+	b, err := bencodeutil.Marshal(dict)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	w.Write(b)
+}
@@ -0,0 +1,186 @@
+/*
+Package tracker is a BitTorrent tracker serving announces and scrapes over
+HTTP and UDP (BEP 15)
+*/
+package tracker
+
+import (
+	"crypto/rand"
+	"errors"
+	"net/netip"
+	"sync"
+	"time"
+)
+
+// Errors returned to peers as failure reasons
+var (
+	ErrUnknownInfoHash = errors.New("unknown info hash")
+	ErrInvalidRequest  = errors.New("invalid request")
+)
+
+// Server is a BitTorrent tracker, the zero value is not usable, use NewServer
+type Server struct {
+	store Store
+
+	// Interval and MinInterval are the announce intervals sent to peers
+	Interval    time.Duration
+	MinInterval time.Duration
+	// PeerTTL is how long a peer is kept after its last announce
+	PeerTTL time.Duration
+	// NumWant is the number of peers returned when the peer does not ask,
+	// MaxNumWant caps what peers may ask for
+	NumWant    int
+	MaxNumWant int
+
+	mu        sync.RWMutex
+	whitelist map[string]bool
+	secret    []byte
+}
+
+// announceEvent is the event of an announce
+type announceEvent string
+
+// announceEvent Constants
+const (
+	eventNone      announceEvent = ""
+	eventStarted   announceEvent = "started"
+	eventCompleted announceEvent = "completed"
+	eventStopped   announceEvent = "stopped"
+)
+
+// announceRequest is an announce of either protocol
+type announceRequest struct {
+	infoHash string
+	peerID   string
+	addr     netip.AddrPort
+	left     int64
+	event    announceEvent
+	numWant  int
+}
+
+// announceResponse is the answer to an announce
+type announceResponse struct {
+	counts Counts
+	peers  []Peer
+}
+
+// NewServer returns a tracker keeping its swarms in the store
+func NewServer(store Store) *Server {
+	secret := make([]byte, 32)
+	rand.Read(secret)
+	return &Server{
+		store:       store,
+		Interval:    30 * time.Minute,
+		MinInterval: 5 * time.Minute,
+		PeerTTL:     45 * time.Minute,
+		NumWant:     50,
+		MaxNumWant:  200,
+		whitelist:   make(map[string]bool),
+		secret:      secret,
+	}
+}
+
+// Allow adds the info hashes to the whitelist, once it is not empty only
+// the info hashes in it are tracked
+func (s *Server) Allow(infoHashes ...[]byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, h := range infoHashes {
+		s.whitelist[string(h)] = true
+	}
+}
+
+// Disallow removes the info hashes from the whitelist
+func (s *Server) Disallow(infoHashes ...[]byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, h := range infoHashes {
+		delete(s.whitelist, string(h))
+	}
+}
+
+func (s *Server) allowed(infoHash string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.whitelist) == 0 || s.whitelist[infoHash]
+}
+
+// announce updates the swarm with the request and returns the peers and
+// counts to answer with, the peers exclude the requesting one
+func (s *Server) announce(req *announceRequest) (*announceResponse, error) {
+	if len(req.infoHash) != 20 || len(req.peerID) != 20 || !req.addr.IsValid() || req.addr.Port() == 0 {
+		return nil, ErrInvalidRequest
+	}
+	if !s.allowed(req.infoHash) {
+		return nil, ErrUnknownInfoHash
+	}
+
+	if req.event == eventStopped {
+		err := s.store.DeletePeer(req.infoHash, req.peerID)
+		if err != nil {
+			return nil, err
+		}
+		counts, err := s.store.Counts(req.infoHash)
+		if err != nil {
+			return nil, err
+		}
+		return &announceResponse{counts: counts, peers: []Peer{}}, nil
+	}
+
+	err := s.store.PutPeer(req.infoHash, Peer{
+		ID:      req.peerID,
+		Addr:    req.addr,
+		Left:    req.left,
+		Expires: time.Now().Add(s.PeerTTL),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if req.event == eventCompleted {
+		err = s.store.AddCompleted(req.infoHash)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	numWant := req.numWant
+	if numWant < 0 {
+		numWant = s.NumWant
+	}
+	if numWant > s.MaxNumWant {
+		numWant = s.MaxNumWant
+	}
+	peers, err := s.store.Peers(req.infoHash, numWant+1)
+	if err != nil {
+		return nil, err
+	}
+	res := &announceResponse{peers: make([]Peer, 0, len(peers))}
+	for _, p := range peers {
+		// seeders have no use for other seeders
+		if p.ID == req.peerID || (req.left == 0 && p.Seeder()) || len(res.peers) >= numWant {
+			continue
+		}
+		res.peers = append(res.peers, p)
+	}
+	res.counts, err = s.store.Counts(req.infoHash)
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// scrape returns the counts of the info hashes that are tracked
+func (s *Server) scrape(infoHashes []string) (map[string]Counts, error) {
+	files := make(map[string]Counts)
+	for _, h := range infoHashes {
+		if len(h) != 20 || !s.allowed(h) {
+			continue
+		}
+		counts, err := s.store.Counts(h)
+		if err != nil {
+			return nil, err
+		}
+		files[h] = counts
+	}
+	return files, nil
+}
@@ -0,0 +1,238 @@
+package tracker
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	bencode "github.com/tharindu96/bencode-go"
+)
+
+var testInfoHash = strings.Repeat("h", 20)
+
+func announceHTTP(t *testing.T, u string, peerID string, port string, left string) bencode.BDict {
+	vals := url.Values{}
+	vals.Set("info_hash", testInfoHash)
+	vals.Set("peer_id", peerID)
+	vals.Set("port", port)
+	vals.Set("left", left)
+	res, err := http.Get(u + "/announce?" + vals.Encode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	node, err := bencode.BRead(bufio.NewReader(res.Body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dict, err := node.GetDict()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f := dict.Get("failure reason"); f != nil {
+		s, _ := f.GetString()
+		t.Fatal(s.ToString())
+	}
+	return dict
+}
+
+func Test_HTTPAnnounce(t *testing.T) {
+	server := NewServer(NewMemoryStore())
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	announceHTTP(t, ts.URL, strings.Repeat("a", 20), "6881", "0")
+	dict := announceHTTP(t, ts.URL, strings.Repeat("b", 20), "6882", "100")
+
+	peers, _ := dict.Get("peers").GetString()
+	if peers.ToString() != "\x7f\x00\x00\x01\x1a\xe1" {
+		t.Errorf("unexpected peers %q", peers.ToString())
+	}
+	complete, _ := dict.Get("complete").GetInteger()
+	incomplete, _ := dict.Get("incomplete").GetInteger()
+	if complete.ToInt() != 1 || incomplete.ToInt() != 1 {
+		t.Errorf("unexpected counts %d %d", complete, incomplete)
+	}
+
+	res, err := http.Get(ts.URL + "/scrape?info_hash=" + url.QueryEscape(testInfoHash))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	node, err := bencode.BRead(bufio.NewReader(res.Body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, _ := node.GetBencode()
+	if s != "d5:filesd20:"+testInfoHash+"d8:completei1e10:downloadedi0e10:incompletei1eeee" {
+		t.Errorf("unexpected scrape %q", s)
+	}
+
+	server.Allow([]byte(strings.Repeat("x", 20)))
+	res, err = http.Get(ts.URL + "/announce?info_hash=" + url.QueryEscape(testInfoHash) +
+		"&peer_id=" + strings.Repeat("c", 20) + "&port=1&left=0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	node, _ = bencode.BRead(bufio.NewReader(res.Body))
+	s, _ = node.GetBencode()
+	if s != "d14:failure reason17:unknown info hashe" {
+		t.Errorf("not whitelisted info hash accepted %q", s)
+	}
+}
+
+func Test_UDPAnnounce(t *testing.T) {
+	server := NewServer(NewMemoryStore())
+	pc, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go server.ServeUDP(ctx, pc)
+
+	conn, err := net.Dial("udp4", pc.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	roundTrip := func(req []byte) []byte {
+		if _, err := conn.Write(req); err != nil {
+			t.Fatal(err)
+		}
+		buff := make([]byte, udpMaxPacket)
+		n, err := conn.Read(buff)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return buff[:n]
+	}
+
+	req := make([]byte, 16)
+	binary.BigEndian.PutUint64(req[0:8], udpProtocolID)
+	res := roundTrip(req)
+	connID := binary.BigEndian.Uint64(res[8:16])
+
+	server.store.PutPeer(testInfoHash, Peer{
+		ID:      strings.Repeat("a", 20),
+		Addr:    netip.MustParseAddrPort("10.0.0.1:6881"),
+		Expires: time.Now().Add(time.Minute),
+	})
+	req = make([]byte, 98)
+	binary.BigEndian.PutUint64(req[0:8], connID)
+	binary.BigEndian.PutUint32(req[8:12], udpActionAnnounce)
+	copy(req[16:36], testInfoHash)
+	copy(req[36:56], strings.Repeat("b", 20))
+	binary.BigEndian.PutUint64(req[64:72], 100)
+	binary.BigEndian.PutUint32(req[80:84], 2)
+	binary.BigEndian.PutUint32(req[92:96], 0xffffffff)
+	binary.BigEndian.PutUint16(req[96:98], 6882)
+	res = roundTrip(req)
+	if binary.BigEndian.Uint32(res[0:4]) != udpActionAnnounce {
+		t.Fatalf("unexpected response %q", res)
+	}
+	if seeders := binary.BigEndian.Uint32(res[16:20]); seeders != 1 || string(res[20:]) != "\x0a\x00\x00\x01\x1a\xe1" {
+		t.Errorf("unexpected announce response %d %q", seeders, res[20:])
+	}
+
+	binary.BigEndian.PutUint64(req[0:8], connID+1)
+	res = roundTrip(req)
+	if binary.BigEndian.Uint32(res[0:4]) != udpActionError {
+		t.Error("invalid connection id accepted")
+	}
+}
+
+func Test_UDPConnIDAcrossSockets(t *testing.T) {
+	server := NewServer(NewMemoryStore())
+	pc, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go server.ServeUDP(ctx, pc)
+
+	// every request is sent from a new socket, so from a new port
+	roundTrip := func(req []byte) []byte {
+		conn, err := net.Dial("udp4", pc.LocalAddr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		if _, err := conn.Write(req); err != nil {
+			t.Fatal(err)
+		}
+		buff := make([]byte, udpMaxPacket)
+		n, err := conn.Read(buff)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return buff[:n]
+	}
+
+	req := make([]byte, 16)
+	binary.BigEndian.PutUint64(req[0:8], udpProtocolID)
+	connID := binary.BigEndian.Uint64(roundTrip(req)[8:16])
+
+	req = make([]byte, 98)
+	binary.BigEndian.PutUint64(req[0:8], connID)
+	binary.BigEndian.PutUint32(req[8:12], udpActionAnnounce)
+	copy(req[16:36], testInfoHash)
+	copy(req[36:56], strings.Repeat("b", 20))
+	binary.BigEndian.PutUint32(req[80:84], 2)
+	binary.BigEndian.PutUint16(req[96:98], 6882)
+	for i := 0; i < 2; i++ {
+		if res := roundTrip(req); binary.BigEndian.Uint32(res[0:4]) != udpActionAnnounce {
+			t.Fatalf("announce %d: unexpected response %q", i, res)
+		}
+	}
+
+	req = make([]byte, 36)
+	binary.BigEndian.PutUint64(req[0:8], connID)
+	binary.BigEndian.PutUint32(req[8:12], udpActionScrape)
+	copy(req[16:36], testInfoHash)
+	res := roundTrip(req)
+	if binary.BigEndian.Uint32(res[0:4]) != udpActionScrape || len(res) != 20 {
+		t.Fatalf("unexpected scrape response %q", res)
+	}
+	if seeders := binary.BigEndian.Uint32(res[8:12]); seeders != 1 {
+		t.Errorf("expected a seeder, got %d", seeders)
+	}
+}
+
+func Test_MemoryStoreSweep(t *testing.T) {
+	store := NewMemoryStore()
+	expired := time.Now().Add(-time.Second)
+	store.PutPeer("a", Peer{ID: "1", Expires: expired})
+	store.PutPeer("b", Peer{ID: "2", Expires: expired})
+	store.PutPeer("c", Peer{ID: "3", Expires: time.Now().Add(time.Hour)})
+	store.PutPeer("d", Peer{ID: "4", Expires: expired})
+	store.AddCompleted("d")
+
+	store.mu.Lock()
+	store.lastSweep = time.Time{}
+	store.mu.Unlock()
+	if _, err := store.Counts("c"); err != nil {
+		t.Fatal(err)
+	}
+
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	if len(store.swarms) != 2 || store.swarms["c"] == nil || store.swarms["d"] == nil {
+		t.Errorf("unexpected swarms after the sweep %v", store.swarms)
+	}
+	if n := len(store.swarms["d"].peers); n != 0 {
+		t.Errorf("expired peers left in the swarm %d", n)
+	}
+}
@@ -0,0 +1,189 @@
+package tracker
+
+import (
+	"net/netip"
+	"sync"
+	"time"
+)
+
+// Peer is a peer of a swarm as announced to the tracker
+type Peer struct {
+	ID      string
+	Addr    netip.AddrPort
+	Left    int64
+	Expires time.Time
+}
+
+// Seeder returns whether the peer has the whole content
+func (p Peer) Seeder() bool {
+	return p.Left == 0
+}
+
+// Counts holds the swarm counts of an info hash
+type Counts struct {
+	Seeders   int
+	Leechers  int
+	Completed int
+}
+
+// Store keeps the peers of the swarms of the tracker. Info hashes and peer
+// ids are raw strings, peers past their Expires time must not be returned
+// or counted.
+type Store interface {
+	// PutPeer adds the peer to the swarm or updates it
+	PutPeer(infoHash string, peer Peer) error
+	// DeletePeer removes the peer with the id from the swarm
+	DeletePeer(infoHash string, id string) error
+	// Peers returns at most n peers of the swarm
+	Peers(infoHash string, n int) ([]Peer, error)
+	// Counts returns the swarm counts
+	Counts(infoHash string) (Counts, error)
+	// AddCompleted counts a completed download in the swarm
+	AddCompleted(infoHash string) error
+}
+
+// sweepInterval is how often the MemoryStore drops the expired peers of all
+// the swarms
+const sweepInterval = time.Minute
+
+// MemoryStore is a Store keeping the swarms in memory, expired peers are
+// dropped when their swarm is updated and from all the swarms every
+// sweepInterval. Swarms left without peers or completed downloads are
+// deleted.
+type MemoryStore struct {
+	mu        sync.Mutex
+	swarms    map[string]*swarm
+	lastSweep time.Time
+}
+
+type swarm struct {
+	peers     map[string]Peer
+	completed int
+}
+
+// NewMemoryStore returns an empty MemoryStore
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		swarms: make(map[string]*swarm),
+	}
+}
+
+// PutPeer adds the peer to the swarm or updates it
+func (s *MemoryStore) PutPeer(infoHash string, peer Peer) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.sweep()
+	sw := s.swarms[infoHash]
+	if sw == nil {
+		sw = &swarm{peers: make(map[string]Peer)}
+		s.swarms[infoHash] = sw
+	}
+	sw.expire(now)
+	sw.peers[peer.ID] = peer
+	return nil
+}
+
+// DeletePeer removes the peer with the id from the swarm
+func (s *MemoryStore) DeletePeer(infoHash string, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.sweep()
+	sw := s.swarms[infoHash]
+	if sw == nil {
+		return nil
+	}
+	delete(sw.peers, id)
+	sw.expire(now)
+	if sw.empty() {
+		delete(s.swarms, infoHash)
+	}
+	return nil
+}
+
+// Peers returns at most n peers of the swarm in random order
+func (s *MemoryStore) Peers(infoHash string, n int) ([]Peer, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.sweep()
+	peers := make([]Peer, 0)
+	sw := s.swarms[infoHash]
+	if sw == nil {
+		return peers, nil
+	}
+	for _, p := range sw.peers {
+		if len(peers) >= n {
+			break
+		}
+		if p.Expires.After(now) {
+			peers = append(peers, p)
+		}
+	}
+	return peers, nil
+}
+
+// Counts returns the swarm counts
+func (s *MemoryStore) Counts(infoHash string) (Counts, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.sweep()
+	var counts Counts
+	sw := s.swarms[infoHash]
+	if sw == nil {
+		return counts, nil
+	}
+	for _, p := range sw.peers {
+		if !p.Expires.After(now) {
+			continue
+		}
+		if p.Seeder() {
+			counts.Seeders++
+		} else {
+			counts.Leechers++
+		}
+	}
+	counts.Completed = sw.completed
+	return counts, nil
+}
+
+// AddCompleted counts a completed download in the swarm
+func (s *MemoryStore) AddCompleted(infoHash string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sweep()
+	sw := s.swarms[infoHash]
+	if sw == nil {
+		sw = &swarm{peers: make(map[string]Peer)}
+		s.swarms[infoHash] = sw
+	}
+	sw.completed++
+	return nil
+}
+
+// sweep drops the expired peers of all the swarms and the swarms left empty
+// once every sweepInterval, it returns the current time. s.mu must be held.
+func (s *MemoryStore) sweep() time.Time {
+	now := time.Now()
+	if now.Sub(s.lastSweep) < sweepInterval {
+		return now
+	}
+	s.lastSweep = now
+	for infoHash, sw := range s.swarms {
+		sw.expire(now)
+		if sw.empty() {
+			delete(s.swarms, infoHash)
+		}
+	}
+	return now
+}
+
+func (sw *swarm) empty() bool {
+	return len(sw.peers) == 0 && sw.completed == 0
+}
+
+func (sw *swarm) expire(now time.Time) {
+	for id, p := range sw.peers {
+		if !p.Expires.After(now) {
+			delete(sw.peers, id)
+		}
+	}
+}
@@ -0,0 +1,178 @@
+package tracker
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"net"
+	"net/netip"
+	"time"
+)
+
+// UDP tracker protocol (BEP 15)
+const (
+	udpProtocolID     = 0x41727101980
+	udpActionConnect  = 0
+	udpActionAnnounce = 1
+	udpActionScrape   = 2
+	udpActionError    = 3
+
+	udpConnIDWindow = 2 * time.Minute
+	udpMaxPacket    = 2048
+	udpMaxScrape    = 74
+)
+
+var udpEvents = [...]announceEvent{eventNone, eventCompleted, eventStarted, eventStopped}
+
+// ServeUDP serves the UDP tracker protocol on the connection until the
+// context is done
+func (s *Server) ServeUDP(ctx context.Context, conn net.PacketConn) error {
+	stop := context.AfterFunc(ctx, func() {
+		conn.Close()
+	})
+	defer stop()
+
+	buff := make([]byte, udpMaxPacket)
+	for {
+		n, addr, err := conn.ReadFrom(buff)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			var nerr net.Error
+			if errors.As(err, &nerr) && nerr.Timeout() {
+				continue
+			}
+			return err
+		}
+		udpAddr, ok := addr.(*net.UDPAddr)
+		if !ok || n < 16 {
+			continue
+		}
+		res := s.handleUDP(buff[:n], udpAddr.AddrPort())
+		if res != nil {
+			conn.WriteTo(res, addr)
+		}
+	}
+}
+
+// handleUDP returns the response to the packet, nil if there is none
+func (s *Server) handleUDP(req []byte, from netip.AddrPort) []byte {
+	connID := binary.BigEndian.Uint64(req[0:8])
+	action := binary.BigEndian.Uint32(req[8:12])
+	tid := req[12:16]
+
+	if action == udpActionConnect {
+		if connID != udpProtocolID {
+			return nil
+		}
+		res := make([]byte, 16)
+		copy(res[4:8], tid)
+		binary.BigEndian.PutUint64(res[8:16], s.udpConnID(from, time.Now()))
+		return res
+	}
+	if !s.validUDPConnID(connID, from) {
+		return udpError(tid, "invalid connection id")
+	}
+
+	switch action {
+	case udpActionAnnounce:
+		return s.handleUDPAnnounce(req, from)
+	case udpActionScrape:
+		return s.handleUDPScrape(req)
+	default:
+		return udpError(tid, "unknown action")
+	}
+}
+
+func (s *Server) handleUDPAnnounce(req []byte, from netip.AddrPort) []byte {
+	tid := req[12:16]
+	if len(req) < 98 {
+		return udpError(tid, ErrInvalidRequest.Error())
+	}
+	event := binary.BigEndian.Uint32(req[80:84])
+	if event >= uint32(len(udpEvents)) {
+		return udpError(tid, ErrInvalidRequest.Error())
+	}
+	ip := from.Addr().Unmap()
+	areq := &announceRequest{
+		infoHash: string(req[16:36]),
+		peerID:   string(req[36:56]),
+		left:     int64(binary.BigEndian.Uint64(req[64:72])),
+		event:    udpEvents[event],
+		numWant:  int(int32(binary.BigEndian.Uint32(req[92:96]))),
+		addr:     netip.AddrPortFrom(ip, binary.BigEndian.Uint16(req[96:98])),
+	}
+	ares, err := s.announce(areq)
+	if err != nil {
+		return udpError(tid, err.Error())
+	}
+
+	res := make([]byte, 20, 20+len(ares.peers)*18)
+	binary.BigEndian.PutUint32(res[0:4], udpActionAnnounce)
+	copy(res[4:8], tid)
+	binary.BigEndian.PutUint32(res[8:12], uint32(s.Interval.Seconds()))
+	binary.BigEndian.PutUint32(res[12:16], uint32(ares.counts.Leechers))
+	binary.BigEndian.PutUint32(res[16:20], uint32(ares.counts.Seeders))
+	// the peers are of the address family the request came from
+	peers, peers6 := compactPeers(ares.peers)
+	if ip.Is4() {
+		res = append(res, peers...)
+	} else {
+		res = append(res, peers6...)
+	}
+	return res
+}
+
+func (s *Server) handleUDPScrape(req []byte) []byte {
+	tid := req[12:16]
+	hashes := req[16:]
+	if len(hashes)%20 != 0 || len(hashes)/20 > udpMaxScrape {
+		return udpError(tid, ErrInvalidRequest.Error())
+	}
+	infoHashes := make([]string, 0, len(hashes)/20)
+	for i := 0; i < len(hashes); i += 20 {
+		infoHashes = append(infoHashes, string(hashes[i:i+20]))
+	}
+	files, err := s.scrape(infoHashes)
+	if err != nil {
+		return udpError(tid, err.Error())
+	}
+
+	res := make([]byte, 8, 8+12*len(infoHashes))
+	binary.BigEndian.PutUint32(res[0:4], udpActionScrape)
+	copy(res[4:8], tid)
+	for _, h := range infoHashes {
+		c := files[h]
+		res = binary.BigEndian.AppendUint32(res, uint32(c.Seeders))
+		res = binary.BigEndian.AppendUint32(res, uint32(c.Completed))
+		res = binary.BigEndian.AppendUint32(res, uint32(c.Leechers))
+	}
+	return res
+}
+
+// udpConnID derives the connection id of the address for the time window of
+// t, so no state is kept between connect and announce. Only the IP is used,
+// clients may send each request from a new port (BEP 15).
+func (s *Server) udpConnID(addr netip.AddrPort, t time.Time) uint64 {
+	mac := hmac.New(sha256.New, s.secret)
+	b, _ := addr.Addr().Unmap().MarshalBinary()
+	mac.Write(b)
+	mac.Write(binary.BigEndian.AppendUint64(nil, uint64(t.Unix()/int64(udpConnIDWindow.Seconds()))))
+	return binary.BigEndian.Uint64(mac.Sum(nil))
+}
+
+// validUDPConnID accepts ids of the current and the previous time window
+func (s *Server) validUDPConnID(id uint64, addr netip.AddrPort) bool {
+	now := time.Now()
+	return id == s.udpConnID(addr, now) || id == s.udpConnID(addr, now.Add(-udpConnIDWindow))
+}
+
+func udpError(tid []byte, msg string) []byte {
+	res := make([]byte, 8, 8+len(msg))
+	binary.BigEndian.PutUint32(res[0:4], udpActionError)
+	copy(res[4:8], tid)
+	return append(res, msg...)
+}
@@ -0,0 +1,330 @@
+package torrentclient
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	bencode "github.com/tharindu96/bencode-go"
+	"github.com/tharindu96/torrentclient-go/tracker"
+)
+
+func Test_HTTPTrackerPeers6(t *testing.T) {
+	torrent, _ := newTestTorrent(t, 16, 20)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		peers := "\x7f\x00\x00\x01\x1a\xe1"
+		peers6 := "\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x01\x1a\xe2"
+		w.Write([]byte("d8:intervali900e5:peers6:" + peers + "6:peers618:" + peers6 + "e"))
+	}))
+	defer server.Close()
+
+	tracker := NewTracker(server.URL, 0, torrent)
+	peers, err := tracker.requestPeers(context.Background(), eventStarted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(peers) != 2 || peers[0].Addr.String() != "127.0.0.1:6881" || peers[1].Addr.String() != "[::1]:6882" {
+		t.Errorf("unexpected peers %v %v", peers[0].Addr, peers[1].Addr)
+	}
+}
+
+func Test_UDPTrackerIPv6(t *testing.T) {
+	torrent, _ := newTestTorrent(t, 16, 20)
+
+	conn, err := net.ListenPacket("udp6", "[::1]:0")
+	if err != nil {
+		t.Skip("IPv6 not available:", err)
+	}
+	defer conn.Close()
+
+	go func() {
+		buff := make([]byte, 2048)
+		for {
+			n, addr, err := conn.ReadFrom(buff)
+			if err != nil {
+				return
+			}
+			req := buff[:n]
+			res := make([]byte, 16)
+			copy(res[4:8], req[12:16])
+			switch binary.BigEndian.Uint32(req[8:12]) {
+			case udpActionConnect:
+				binary.BigEndian.PutUint64(res[8:16], 42)
+			case udpActionAnnounce:
+				if binary.BigEndian.Uint64(req[0:8]) != 42 {
+					continue
+				}
+				res = make([]byte, 20, 38)
+				binary.BigEndian.PutUint32(res[0:4], udpActionAnnounce)
+				copy(res[4:8], req[12:16])
+				binary.BigEndian.PutUint32(res[8:12], 1800)
+				res = append(res, net.ParseIP("2001:db8::1")...)
+				res = append(res, 0x1a, 0xe1)
+			}
+			conn.WriteTo(res, addr)
+		}
+	}()
+
+	tracker := NewTracker("udp://"+conn.LocalAddr().String()+"/announce", 0, torrent)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	peers, err := tracker.requestPeers(ctx, eventStarted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tracker.Interval != 1800 || len(peers) != 1 || peers[0].Addr.String() != "[2001:db8::1]:6881" {
+		t.Errorf("unexpected announce result %d %v", tracker.Interval, peers)
+	}
+}
+
+func Test_UDPTrackerServer(t *testing.T) {
+	torrent, _ := newTestTorrent(t, 16, 20)
+
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	go tracker.NewServer(tracker.NewMemoryStore()).ServeUDP(ctx, conn)
+
+	// each request is sent from a new socket with the cached connection id
+	tr := NewTracker("udp://"+conn.LocalAddr().String()+"/announce", 0, torrent)
+	for _, event := range []announceEvent{eventStarted, eventNone} {
+		if _, err := tr.requestPeers(ctx, event); err != nil {
+			t.Fatalf("announce %q: %v", event, err)
+		}
+	}
+	results, err := tr.Scrape(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r := results[string(torrent.InfoHash)]; r == nil || r.Leechers != 1 {
+		t.Errorf("unexpected scrape results %v", results)
+	}
+
+	// a connection id the tracker rejects is replaced
+	tr.mu.Lock()
+	tr.udpConnID++
+	tr.mu.Unlock()
+	if _, err := tr.requestPeers(ctx, eventNone); err != nil {
+		t.Fatalf("rejected connection id not replaced: %v", err)
+	}
+}
+
+func Test_Scrape(t *testing.T) {
+	torrent, _ := newTestTorrent(t, 16, 20)
+
+	for announce, scrape := range map[string]string{
+		"http://example.com/announce":        "http://example.com/scrape",
+		"http://example.com/x/announce.php":  "http://example.com/x/scrape.php",
+		"http://example.com/announce?pk=abc": "http://example.com/scrape?pk=abc",
+		"http://example.com/a":               "",
+	} {
+		u, err := scrapeURL(announce)
+		if u != scrape || (scrape == "" && err != ErrScrapeNotSupported) {
+			t.Errorf("scrape url of %s: %q %v", announce, u, err)
+		}
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/scrape" || len(r.URL.Query()["info_hash"]) != 2 {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte("d5:filesd20:" + string(torrent.InfoHash) + "d8:completei5e10:downloadedi50e10:incompletei10eeee"))
+	}))
+	defer server.Close()
+
+	tracker := NewTracker(server.URL+"/announce", 0, torrent)
+	other := make([]byte, 20)
+	other[0] = 1
+	results, err := tracker.Scrape(context.Background(), torrent.InfoHash, other)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := results[string(torrent.InfoHash)]
+	if len(results) != 1 || r == nil || r.Seeders != 5 || r.Leechers != 10 || r.Completed != 50 {
+		t.Errorf("unexpected scrape results %v", results)
+	}
+}
+
+func Test_Announcer(t *testing.T) {
+	torrent, _ := newTestTorrent(t, 16, 20)
+
+	events := make(chan string, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if q.Get("event") == "stopped" && q.Get("trackerid") != "abc" {
+			t.Errorf("tracker id not echoed: %q", q.Get("trackerid"))
+		}
+		events <- q.Get("event")
+		w.Write([]byte("d8:intervali900e12:min intervali60e10:tracker id3:abc5:peers0:e"))
+	}))
+	defer server.Close()
+
+	torrent.Tiers = [][]*Tracker{{NewTracker(server.URL, 0, torrent)}}
+	torrent.Trackers = flattenTiers(torrent.Tiers)
+	sub := torrent.client.Subscribe(torrent, 16)
+	defer sub.Unsubscribe()
+	torrent.Start(context.Background())
+	if e := <-events; e != "started" {
+		t.Fatalf("expected started, got %q", e)
+	}
+	for e := range sub.C {
+		if _, ok := e.(TrackerAnnouncedEvent); ok {
+			break
+		}
+	}
+	if torrent.GetState() != StateDownloading {
+		t.Errorf("unexpected state %s", torrent.GetState())
+	}
+	torrent.Stop()
+	if e := <-events; e != "stopped" {
+		t.Fatalf("expected stopped, got %q", e)
+	}
+	if torrent.GetState() != StateStopped {
+		t.Errorf("unexpected state %s", torrent.GetState())
+	}
+	if torrent.Trackers[0].announceInterval() != 900*time.Second {
+		t.Errorf("unexpected interval %s", torrent.Trackers[0].announceInterval())
+	}
+}
+
+func Test_AnnounceCompleted(t *testing.T) {
+	torrent, data := newTestTorrent(t, 32, 20)
+
+	events := make(chan string, 10)
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		event := r.URL.Query().Get("event")
+		events <- event
+		if event == "started" {
+			<-release
+		}
+		w.Write([]byte("d8:intervali900e5:peers0:e"))
+	}))
+	defer server.Close()
+
+	torrent.Tiers = [][]*Tracker{{NewTracker(server.URL, 0, torrent)}}
+	torrent.Trackers = flattenTiers(torrent.Tiers)
+	torrent.Start(context.Background())
+	defer torrent.Stop()
+	if e := <-events; e != "started" {
+		t.Fatalf("expected started, got %q", e)
+	}
+	// a busy torrent fills the buffers of the subscribers, then the download
+	// completes before the tracker answers the started
+	for i := 0; i < 100; i++ {
+		torrent.client.events.emit(PieceVerifiedEvent{torrentEvent{torrent}, 0})
+	}
+	_, err := torrent.pieceDownloaded(0, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	close(release)
+
+	select {
+	case e := <-events:
+		if e != "completed" {
+			t.Fatalf("expected completed, got %q", e)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("completed not sent")
+	}
+}
+
+func Test_TrackerTiers(t *testing.T) {
+	torrent, _ := newTestTorrent(t, 16, 20)
+
+	meta := "d8:announce9:http://a/13:announce-listll9:http://b/9:http://c/el9:http://d/eee"
+	node, err := bencode.BRead(bufio.NewReader(strings.NewReader(meta)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dict, err := node.GetDict()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tiers, err := getTrackerList(&dict, torrent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tiers) != 2 || len(tiers[0]) != 2 || len(tiers[1]) != 1 || tiers[1][0].URL != "http://d/" || tiers[1][0].Tier != 1 {
+		t.Fatalf("unexpected tiers %v", tiers)
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("d8:intervali900e5:peers0:e"))
+	}))
+	defer server.Close()
+	failing := NewTracker("http://127.0.0.1:1/announce", 0, torrent)
+	working := NewTracker(server.URL, 0, torrent)
+	torrent.Tiers = [][]*Tracker{{failing, working}}
+
+	err = torrent.RequestTrackers(context.Background(), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if torrent.Tiers[0][0] != working || torrent.Tiers[0][1] != failing {
+		t.Error("the tracker that answered was not moved to the front of its tier")
+	}
+}
+
+func Test_HTTPTrackerResponse(t *testing.T) {
+	torrent, _ := newTestTorrent(t, 16, 20)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/old", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/announce?passkey=x&"+r.URL.RawQuery, http.StatusFound)
+	})
+	mux.HandleFunc("/announce", func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		for _, k := range []string{"passkey", "compact", "no_peer_id", "numwant", "key"} {
+			if q.Get(k) == "" {
+				t.Errorf("missing parameter %s", k)
+			}
+		}
+		w.Write([]byte("d8:completei3e10:incompletei4e11:external ip4:\xc0\x00\x02\x018:intervali900e" +
+			"12:min intervali60e5:peers0:15:warning message4:slowe"))
+	})
+	mux.HandleFunc("/denied", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte("d14:failure reason12:unregisterede"))
+	})
+	mux.HandleFunc("/broken", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "<html>bad gateway</html>", http.StatusBadGateway)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	tracker := NewTracker(server.URL+"/old", 0, torrent)
+	_, err := tracker.requestPeers(context.Background(), eventNone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tracker.Seeders != 3 || tracker.Leechers != 4 || tracker.MinInterval != 60 || tracker.Warning != "slow" {
+		t.Errorf("unexpected tracker state %+v", tracker)
+	}
+	if tracker.ExternalIP.String() != "192.0.2.1" {
+		t.Errorf("unexpected external ip %s", tracker.ExternalIP)
+	}
+
+	_, err = NewTracker(server.URL+"/denied", 0, torrent).requestPeers(context.Background(), eventNone)
+	var tfe *TrackerFailureError
+	if !errors.As(err, &tfe) || tfe.Reason != "unregistered" {
+		t.Errorf("failure reason of an error status not returned: %v", err)
+	}
+	_, err = NewTracker(server.URL+"/broken", 0, torrent).requestPeers(context.Background(), eventNone)
+	if err == nil || errors.Is(err, ErrTrackerFailure) || !strings.Contains(err.Error(), "502") {
+		t.Errorf("unexpected error %v", err)
+	}
+}
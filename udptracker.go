@@ -0,0 +1,223 @@
+package torrentclient
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"net"
+	"net/netip"
+	"net/url"
+	"time"
+)
+
+// UDP tracker protocol (BEP 15)
+const (
+	udpProtocolID     = 0x41727101980
+	udpActionConnect  = 0
+	udpActionAnnounce = 1
+	udpActionScrape   = 2
+	udpActionError    = 3
+
+	udpEventNone      = 0
+	udpEventCompleted = 1
+	udpEventStarted   = 2
+	udpEventStopped   = 3
+
+	udpBaseTimeout = 15 * time.Second
+	udpMaxRetries  = 8
+	udpConnIDLife  = time.Minute
+	udpMaxPacket   = 2048
+)
+
+func (tracker *Tracker) requestUDPTracker(ctx context.Context, event announceEvent) ([]*Peer, error) {
+	torrent := tracker.torrent
+	client := torrent.GetClient()
+
+	ctx, cancel := context.WithTimeout(ctx, client.config.TrackerTimeout)
+	defer cancel()
+
+	conn, err := tracker.dialUDP(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	stats := torrent.Stats()
+	req := make([]byte, 98)
+	binary.BigEndian.PutUint32(req[8:12], udpActionAnnounce)
+	copy(req[16:36], torrent.InfoHash)
+	copy(req[36:56], padPeerID(client.GetID()))
+	binary.BigEndian.PutUint64(req[56:64], uint64(stats.DownloadedPayload))
+	binary.BigEndian.PutUint64(req[64:72], uint64(stats.Left))
+	binary.BigEndian.PutUint64(req[72:80], uint64(stats.UploadedPayload))
+	binary.BigEndian.PutUint32(req[80:84], udpEvent(event))
+	if ip, err := netip.ParseAddr(client.config.AnnounceIP); err == nil && ip.Is4() {
+		copy(req[84:88], ip.AsSlice())
+	}
+	binary.BigEndian.PutUint32(req[88:92], client.key)
+	numWant := uint32(client.config.NumWant)
+	if event == eventStopped {
+		numWant = 0
+	}
+	binary.BigEndian.PutUint32(req[92:96], numWant)
+	binary.BigEndian.PutUint16(req[96:98], client.GetPort())
+
+	res, err := tracker.udpRequest(ctx, conn, req, udpActionAnnounce)
+	if err != nil {
+		return nil, err
+	}
+	if len(res) < 20 {
+		return nil, errors.New("udp tracker: short announce response")
+	}
+	tracker.mu.Lock()
+	tracker.Interval = int(binary.BigEndian.Uint32(res[8:12]))
+	tracker.Leechers = int(binary.BigEndian.Uint32(res[12:16]))
+	tracker.Seeders = int(binary.BigEndian.Uint32(res[16:20]))
+	tracker.mu.Unlock()
+
+	// the peers are IPv6 when the tracker is reached over IPv6
+	size := compactPeerLength
+	if addr, ok := conn.RemoteAddr().(*net.UDPAddr); ok && addr.IP.To4() == nil {
+		size = compactPeer6Length
+	}
+	return tracker.parseCompactPeers(res[20:], size), nil
+}
+
+func udpEvent(event announceEvent) uint32 {
+	switch event {
+	case eventCompleted:
+		return udpEventCompleted
+	case eventStarted:
+		return udpEventStarted
+	case eventStopped:
+		return udpEventStopped
+	default:
+		return udpEventNone
+	}
+}
+
+func (tracker *Tracker) dialUDP(ctx context.Context) (net.Conn, error) {
+	u, err := url.Parse(tracker.URL)
+	if err != nil {
+		return nil, err
+	}
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "udp", u.Host)
+	if err != nil {
+		return nil, err
+	}
+	return conn, nil
+}
+
+// udpRequest sets the connection id in req and sends it. A connection id
+// rejected by the tracker is dropped and the request is sent once more with
+// a new one.
+func (tracker *Tracker) udpRequest(ctx context.Context, conn net.Conn, req []byte, action uint32) ([]byte, error) {
+	for retry := true; ; retry = false {
+		connID, cached, err := tracker.udpConnect(ctx, conn)
+		if err != nil {
+			return nil, err
+		}
+		binary.BigEndian.PutUint64(req[0:8], connID)
+		res, err := tracker.udpRoundTrip(ctx, conn, req, action)
+		var ferr *TrackerFailureError
+		if errors.As(err, &ferr) {
+			tracker.mu.Lock()
+			if tracker.udpConnID == connID {
+				tracker.udpConnID = 0
+			}
+			tracker.mu.Unlock()
+			if cached && retry {
+				continue
+			}
+		}
+		return res, err
+	}
+}
+
+// udpConnect returns a connection id and whether it was reused, the last one
+// is reused while it is valid
+func (tracker *Tracker) udpConnect(ctx context.Context, conn net.Conn) (uint64, bool, error) {
+	tracker.mu.Lock()
+	connID, expiry := tracker.udpConnID, tracker.udpConnIDExpiry
+	tracker.mu.Unlock()
+	if connID != 0 && time.Now().Before(expiry) {
+		return connID, true, nil
+	}
+	req := make([]byte, 16)
+	binary.BigEndian.PutUint64(req[0:8], udpProtocolID)
+	binary.BigEndian.PutUint32(req[8:12], udpActionConnect)
+	res, err := tracker.udpRoundTrip(ctx, conn, req, udpActionConnect)
+	if err != nil {
+		return 0, false, err
+	}
+	if len(res) < 16 {
+		return 0, false, errors.New("udp tracker: short connect response")
+	}
+	connID = binary.BigEndian.Uint64(res[8:16])
+	tracker.mu.Lock()
+	tracker.udpConnID = connID
+	tracker.udpConnIDExpiry = time.Now().Add(udpConnIDLife)
+	tracker.mu.Unlock()
+	return connID, false, nil
+}
+
+// udpRoundTrip sets a new transaction id in req, sends it and waits for the
+// matching response, retrying with the timeouts of BEP 15 until the context
+// is done
+func (tracker *Tracker) udpRoundTrip(ctx context.Context, conn net.Conn, req []byte, action uint32) ([]byte, error) {
+	tid := make([]byte, 4)
+	_, err := rand.Read(tid)
+	if err != nil {
+		return nil, err
+	}
+	copy(req[12:16], tid)
+
+	buff := make([]byte, udpMaxPacket)
+	timeout := udpBaseTimeout
+	for i := 0; i <= udpMaxRetries; i++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		_, err := conn.Write(req)
+		if err != nil {
+			return nil, err
+		}
+		deadline := time.Now().Add(timeout)
+		if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+			deadline = d
+		}
+		conn.SetReadDeadline(deadline)
+		for {
+			n, err := conn.Read(buff)
+			if err != nil {
+				var nerr net.Error
+				if errors.As(err, &nerr) && nerr.Timeout() {
+					break
+				}
+				return nil, err
+			}
+			if n < 8 || string(buff[4:8]) != string(tid) {
+				continue
+			}
+			res := buff[:n]
+			switch binary.BigEndian.Uint32(res[0:4]) {
+			case action:
+				return res, nil
+			case udpActionError:
+				return nil, &TrackerFailureError{
+					URL:    tracker.URL,
+					Reason: string(res[8:]),
+				}
+			default:
+				return nil, errors.New("udp tracker: unexpected action")
+			}
+		}
+		timeout *= 2
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return nil, errors.New("udp tracker: no response")
+}
@@ -0,0 +1,295 @@
+package torrentclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	bencode "github.com/tharindu96/bencode-go"
+)
+
+const (
+	webSeedMinBackoff = 5 * time.Second
+	webSeedMaxBackoff = 10 * time.Minute
+	webSeedIdleWait   = time.Second
+	// seedReadChunk is how much of a seed response is read at a time, each
+	// chunk waits for the rate limiters
+	seedReadChunk = 16 << 10
+)
+
+// WebSeed is an HTTP server hosting the content of a torrent (BEP 19), it is
+// used like a peer that has every piece
+type WebSeed struct {
+	torrent *Torrent
+	URL     string
+	stats   transferStats
+}
+
+// pieceSource downloads whole pieces outside of the peer wire protocol
+type pieceSource interface {
+	fetchPiece(ctx context.Context, index int) ([]byte, error)
+	statsGroup() statsGroup
+}
+
+// retryAfterError is returned by a piece source asked to come back later
+type retryAfterError struct {
+	after time.Duration
+}
+
+func (e *retryAfterError) Error() string {
+	return fmt.Sprintf("retry after %s", e.after)
+}
+
+// Run downloads pieces from the web seed until the context is done or every
+// wanted piece is complete. A failing server is retried with an exponential
+// backoff.
+func (ws *WebSeed) Run(ctx context.Context) error {
+	return runPieceSource(ctx, ws.torrent, ws)
+}
+
+// Stats returns the transfer statistics of the web seed
+func (ws *WebSeed) Stats() Stats {
+	return ws.stats.get()
+}
+
+func (ws *WebSeed) statsGroup() statsGroup {
+	return statsGroup{&ws.stats, &ws.torrent.stats, &ws.torrent.client.stats}
+}
+
+func runPieceSource(ctx context.Context, torrent *Torrent, source pieceSource) error {
+	failures := 0
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		index := torrent.pickPiece(func(int) bool { return true }, false)
+		if index < 0 {
+			torrent.mu.Lock()
+			done := torrent.isWantedComplete()
+			torrent.mu.Unlock()
+			if done {
+				return nil
+			}
+			if err := sleepContext(ctx, webSeedIdleWait); err != nil {
+				return err
+			}
+			continue
+		}
+
+		data, err := source.fetchPiece(ctx, index)
+		ok := false
+		if err == nil {
+			ok, err = torrent.pieceDownloaded(index, data)
+			if err != nil {
+				torrent.releasePiece(index)
+				return err
+			}
+			if !ok {
+				source.statsGroup().addWasted(len(data))
+			}
+		}
+		torrent.releasePiece(index)
+
+		if ok {
+			failures = 0
+			continue
+		}
+		wait := webSeedMinBackoff
+		var retry *retryAfterError
+		if errors.As(err, &retry) {
+			wait = retry.after
+		} else {
+			failures++
+			for i := 1; i < failures && wait < webSeedMaxBackoff; i++ {
+				wait *= 2
+			}
+			if wait > webSeedMaxBackoff {
+				wait = webSeedMaxBackoff
+			}
+		}
+		if err := sleepContext(ctx, wait); err != nil {
+			return err
+		}
+	}
+}
+
+// fileURL returns the URL of the file at index on the server
+func (ws *WebSeed) fileURL(index int) string {
+	torrent := ws.torrent
+	if !torrent.multiFile {
+		if strings.HasSuffix(ws.URL, "/") {
+			return ws.URL + url.PathEscape(torrent.Name)
+		}
+		return ws.URL
+	}
+	u := ws.URL
+	if !strings.HasSuffix(u, "/") {
+		u += "/"
+	}
+	u += url.PathEscape(torrent.Name)
+	for _, p := range strings.Split(torrent.Files[index].Path, "/") {
+		u += "/" + url.PathEscape(p)
+	}
+	return u
+}
+
+// fetchPiece downloads the piece at index with a Range request for every file
+// the piece covers
+func (ws *WebSeed) fetchPiece(ctx context.Context, index int) ([]byte, error) {
+	torrent := ws.torrent
+	data := make([]byte, torrent.pieceLength(index))
+	err := torrent.storage.each(torrent.pieceOffset(index), len(data), func(file int, fileOff int64, start int, end int) error {
+		// web seeds do not have the pad files
+		if torrent.Files[file].IsPad() {
+			return nil
+		}
+		return ws.fetchRange(ctx, file, fileOff, data[start:end])
+	})
+	if err != nil {
+		return nil, fmt.Errorf("web seed %s: %w", ws.URL, err)
+	}
+	return data, nil
+}
+
+func (ws *WebSeed) fetchRange(ctx context.Context, file int, off int64, buff []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, ws.fileURL(file), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", off, off+int64(len(buff))-1))
+
+	res, err := ws.torrent.fetchSeed(req)
+	if err != nil {
+		return err
+	}
+	defer res.Close()
+
+	switch {
+	case res.StatusCode == http.StatusPartialContent:
+	case res.StatusCode == http.StatusOK && off == 0:
+	default:
+		return fmt.Errorf("unexpected status %s", res.Status)
+	}
+	_, err = res.read(buff, ws.statsGroup())
+	return err
+}
+
+// seedResponse is the response of a web or HTTP seed, the request is canceled
+// when the seed sends nothing for the seed timeout
+type seedResponse struct {
+	*http.Response
+	torrent *Torrent
+	ctx     context.Context
+	cancel  context.CancelFunc
+	timer   *time.Timer
+	timeout time.Duration
+}
+
+// fetchSeed sends the request of a web or HTTP seed
+func (torrent *Torrent) fetchSeed(req *http.Request) (*seedResponse, error) {
+	config := torrent.client.config
+	ctx, cancel := context.WithCancel(req.Context())
+	timer := time.AfterFunc(config.SeedTimeout, cancel)
+	req = req.WithContext(ctx)
+	req.Header.Set("User-Agent", config.UserAgent)
+	res, err := torrent.client.seedClient.Do(req)
+	if err != nil {
+		timer.Stop()
+		cancel()
+		return nil, err
+	}
+	return &seedResponse{
+		Response: res,
+		torrent:  torrent,
+		ctx:      ctx,
+		cancel:   cancel,
+		timer:    timer,
+		timeout:  config.SeedTimeout,
+	}, nil
+}
+
+// read fills buff from the body a chunk at a time, the seed timeout is paused
+// while a chunk waits for the rate limiters
+func (res *seedResponse) read(buff []byte, group statsGroup) (int, error) {
+	download, _ := res.torrent.client.getLimiters(false)
+	n := 0
+	for n < len(buff) {
+		chunk := min(len(buff)-n, seedReadChunk)
+		res.timer.Stop()
+		err := waitAll(res.ctx, chunk, res.torrent.downloadLimiter, download)
+		res.timer.Reset(res.timeout)
+		if err != nil {
+			return n, err
+		}
+		m, err := io.ReadFull(res.Body, buff[n:n+chunk])
+		n += m
+		group.addDownloaded(m)
+		group.addDownloadedPayload(m)
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// Close stops the timer of the request and closes the body
+func (res *seedResponse) Close() error {
+	res.timer.Stop()
+	res.cancel()
+	return res.Body.Close()
+}
+
+func getWebSeeds(tordict *bencode.BDict, torrent *Torrent) ([]*WebSeed, error) {
+	node := tordict.Get("url-list")
+	seeds := make([]*WebSeed, 0)
+	if node == nil {
+		return seeds, nil
+	}
+	urls := make([]string, 0)
+	switch node.Type {
+	case bencode.BencodeString:
+		s, err := node.GetString()
+		if err != nil {
+			return nil, err
+		}
+		urls = append(urls, s.ToString())
+	case bencode.BencodeList:
+		list, err := node.GetList()
+		if err != nil {
+			return nil, err
+		}
+		for _, n := range list {
+			s, err := n.GetString()
+			if err != nil {
+				return nil, err
+			}
+			urls = append(urls, s.ToString())
+		}
+	}
+	for _, u := range urls {
+		if !strings.HasPrefix(u, "http://") && !strings.HasPrefix(u, "https://") {
+			continue
+		}
+		seeds = append(seeds, &WebSeed{
+			torrent: torrent,
+			URL:     u,
+		})
+	}
+	return seeds, nil
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,154 @@
+package torrentclient
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// serveWebSeed serves the files of the torrent and returns the web seed of
+// the server
+func serveWebSeed(t *testing.T, torrent *Torrent, data []byte) *WebSeed {
+	root := t.TempDir()
+	var off uint
+	for _, f := range torrent.Files {
+		p := filepath.Join(root, torrent.Name, filepath.FromSlash(f.Path))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, data[off:off+f.Length], 0644); err != nil {
+			t.Fatal(err)
+		}
+		off += f.Length
+	}
+	server := httptest.NewServer(http.FileServer(http.Dir(root)))
+	t.Cleanup(server.Close)
+	ws := &WebSeed{torrent: torrent, URL: server.URL + "/"}
+	torrent.WebSeeds = []*WebSeed{ws}
+	return ws
+}
+
+// waitComplete starts the torrent and waits for its download to complete
+func waitComplete(t *testing.T, torrent *Torrent) {
+	sub := torrent.client.Subscribe(torrent, 64)
+	defer sub.Unsubscribe()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	torrent.Start(ctx)
+	defer torrent.Stop()
+	for done := false; !done; {
+		select {
+		case e := <-sub.C:
+			_, done = e.(DownloadCompleteEvent)
+		case <-ctx.Done():
+			t.Fatal("download did not complete")
+		}
+	}
+}
+
+func Test_WebSeed(t *testing.T) {
+	torrent, data := newTestTorrent(t, 16, 20, 30)
+	ws := serveWebSeed(t, torrent, data)
+	waitComplete(t, torrent)
+
+	for i, p := range torrent.Pieces {
+		if !p.Complete {
+			t.Errorf("piece %d not downloaded", i)
+		}
+	}
+	if ws.Stats().DownloadedPayload != int64(len(data)) {
+		t.Errorf("unexpected payload count %d", ws.Stats().DownloadedPayload)
+	}
+}
+
+func Test_WebSeedThrottled(t *testing.T) {
+	torrent, data := newTestTorrent(t, 16, 20, 30)
+	// every piece waits for the limiter longer than the seed timeout
+	torrent.client.config.SeedTimeout = 100 * time.Millisecond
+	torrent.downloadLimiter = NewRateLimiter(32)
+	ws := serveWebSeed(t, torrent, data)
+	waitComplete(t, torrent)
+	if ws.Stats().DownloadedPayload != int64(len(data)) {
+		t.Errorf("pieces fetched again, payload count %d", ws.Stats().DownloadedPayload)
+	}
+}
+
+func Test_HTTPSeed(t *testing.T) {
+	torrent, data := newTestTorrent(t, 16, 20, 30)
+
+	var mu sync.Mutex
+	busy := true
+	cut := true
+	ranges := make([]string, 0)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if busy {
+			busy = false
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte("1"))
+			return
+		}
+		if r.URL.Query().Get("info_hash") != string(torrent.InfoHash) {
+			http.NotFound(w, r)
+			return
+		}
+		index, err := strconv.Atoi(r.URL.Query().Get("piece"))
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		var start, end int
+		_, err = fmt.Sscanf(r.URL.Query().Get("ranges"), "%d-%d", &start, &end)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		ranges = append(ranges, fmt.Sprintf("%d:%d-%d", index, start, end))
+		piece := data[index*16 : min((index+1)*16, len(data))]
+		block := piece[start : end+1]
+		if cut {
+			// the connection breaks in the middle of the first piece
+			cut = false
+			w.Header().Set("Content-Length", strconv.Itoa(len(block)))
+			w.Write(block[:8])
+			return
+		}
+		w.Write(block)
+	}))
+	defer server.Close()
+
+	hs := &HTTPSeed{torrent: torrent, URL: server.URL}
+	torrent.HTTPSeeds = []*HTTPSeed{hs}
+	sub := torrent.client.Subscribe(torrent, 64)
+	defer sub.Unsubscribe()
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+	start := time.Now()
+	torrent.Start(ctx)
+	defer torrent.Stop()
+	for done := false; !done; {
+		select {
+		case e := <-sub.C:
+			_, done = e.(DownloadCompleteEvent)
+		case <-ctx.Done():
+			t.Fatal("download did not complete")
+		}
+	}
+	if time.Since(start) < time.Second {
+		t.Error("retry time of the busy server not honored")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(ranges) < 2 || ranges[0] != "0:0-15" || ranges[1] != "0:8-15" {
+		t.Errorf("missing part of the piece not requested alone: %v", ranges)
+	}
+}